@@ -0,0 +1,831 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	metaBucket          = []byte("meta")
+	categoriesBucket    = []byte("categories")
+	subscriptionsBucket = []byte("subscriptions")
+	sentVacanciesBucket = []byte("sentVacancies")
+	pendingBucket       = []byte("pendingVacancies")
+	outboxBucket        = []byte("outbox")
+	categoryListBucket  = []byte("categoryList")
+	channelLinksBucket  = []byte("channelLinks")
+	schemaVersionKey    = []byte("schemaVersion")
+)
+
+// boltMigrations are applied in order, schema version is the amount of already applied migrations
+var boltMigrations = []func(tx *bolt.Tx) error{
+	func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(categoriesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(subscriptionsBucket)
+		return err
+	},
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sentVacanciesBucket)
+		return err
+	},
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(pendingBucket)
+		return err
+	},
+	// records are written back under the key they were read from, re-keying is done by the next migration
+	func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(subscriptionsBucket)
+		updated := map[string][]byte{}
+		err := bucket.ForEach(func(k, v []byte) error {
+			var subInfo SubscriptionInfo
+			if err := json.Unmarshal(v, &subInfo); err != nil {
+				return err
+			}
+			if !assignSubscriptionIds(subInfo.Subscriptions) {
+				return nil
+			}
+
+			data, err := json.Marshal(subInfo)
+			if err != nil {
+				return err
+			}
+			updated[string(k)] = data
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for k, data := range updated {
+			if err := bucket.Put([]byte(k), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// subscriptions used to be keyed by user id, for private chats it equals chat id
+	func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(subscriptionsBucket)
+		moved := map[string]SubscriptionInfo{}
+		err := bucket.ForEach(func(k, v []byte) error {
+			var subInfo SubscriptionInfo
+			if err := json.Unmarshal(v, &subInfo); err != nil {
+				return err
+			}
+			if string(k) != string(chatKey(subInfo.ChatId)) {
+				moved[string(k)] = subInfo
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for k, subInfo := range moved {
+			if err := bucket.Delete([]byte(k)); err != nil {
+				return err
+			}
+			if err := putBoltSubscriptionInfo(tx, subInfo); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(outboxBucket)
+		return err
+	},
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(categoryListBucket)
+		return err
+	},
+	// pending vacancies remember when they were queued, already queued ones get zero date
+	func(tx *bolt.Tx) error {
+		pending := tx.Bucket(pendingBucket)
+		chatIds := [][]byte{}
+		err := pending.ForEachBucket(func(k []byte) error {
+			chatIds = append(chatIds, append([]byte{}, k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, chatId := range chatIds {
+			bucket := pending.Bucket(chatId)
+			updated := map[string][]byte{}
+			err := bucket.ForEach(func(k, v []byte) error {
+				var vacancy VacancyRecord
+				if err := json.Unmarshal(v, &vacancy); err != nil {
+					return err
+				}
+
+				data, err := json.Marshal(PendingVacancy{Vacancy: vacancy})
+				if err != nil {
+					return err
+				}
+				updated[string(k)] = data
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for k, data := range updated {
+				if err := bucket.Put([]byte(k), data); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	},
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(channelLinksBucket)
+		return err
+	},
+}
+
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+func CreateBoltStorage() (*BoltStorage, error) {
+	path := os.Getenv("BOLT_PATH")
+	if path == "" {
+		path = "dou.db"
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrateBolt(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStorage{db: db}, nil
+}
+
+func migrateBolt(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+
+		version := 0
+		if v := meta.Get(schemaVersionKey); v != nil {
+			version = int(binary.BigEndian.Uint64(v))
+		}
+
+		for ; version < len(boltMigrations); version++ {
+			fmt.Printf("Applying bolt migration %d\n", version+1)
+			if err := boltMigrations[version](tx); err != nil {
+				return fmt.Errorf("migration %d failed: %w", version+1, err)
+			}
+		}
+
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, uint64(version))
+		return meta.Put(schemaVersionKey, v)
+	})
+}
+
+func (bs *BoltStorage) Close(ctx context.Context) error {
+	return bs.db.Close()
+}
+
+func (bs *BoltStorage) GetAllSubscribers(ctx context.Context, categoryName string, categoryId string, exp string) ([]SubscriptionInfo, error) {
+	res := []SubscriptionInfo{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(subscriptionsBucket).ForEach(func(k, v []byte) error {
+			var subInfo SubscriptionInfo
+			if err := json.Unmarshal(v, &subInfo); err != nil {
+				return err
+			}
+			if subInfo.Inactive {
+				return nil
+			}
+
+			for _, sub := range subInfo.Subscriptions {
+				if sub.IDCategory == IdToDBId(categoryId) && sub.Experience == IdToDBId(exp) {
+					res = append(res, subInfo)
+					break
+				}
+			}
+			return nil
+		})
+	})
+
+	return res, err
+}
+
+func (bs *BoltStorage) GetSubscriptionInfo(ctx context.Context, chatId int64) (SubscriptionInfo, error) {
+	var res SubscriptionInfo
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		found, err := getBoltSubscriptionInfo(tx, chatId, &res)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("Subscription info for chat %d wasn't found", chatId)
+		}
+		return nil
+	})
+
+	return res, err
+}
+
+func (bs *BoltStorage) UnsubscribeUser(ctx context.Context, subscriptionId string, chatId int64) (bool, error) {
+	isFound := false
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		var subInfo SubscriptionInfo
+		found, err := getBoltSubscriptionInfo(tx, chatId, &subInfo)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("Subscription info for chat %d wasn't found", chatId)
+		}
+
+		if subInfo.Subscriptions, isFound = removeSubscription(subInfo.Subscriptions, subscriptionId); !isFound {
+			return nil
+		}
+
+		return putBoltSubscriptionInfo(tx, subInfo)
+	})
+
+	return isFound, err
+}
+
+func (bs *BoltStorage) UpdateSubscription(ctx context.Context, chatId int64, sub SubscriptionCategory) (bool, error) {
+	isFound := false
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		var subInfo SubscriptionInfo
+		found, err := getBoltSubscriptionInfo(tx, chatId, &subInfo)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("Subscription info for chat %d wasn't found", chatId)
+		}
+
+		if isFound, err = replaceSubscription(subInfo.Subscriptions, sub); !isFound || err != nil {
+			return err
+		}
+
+		return putBoltSubscriptionInfo(tx, subInfo)
+	})
+
+	return isFound, err
+}
+
+func (bs *BoltStorage) SubscribeUser(ctx context.Context, subCategory SubscriptionCategory, userId int, chatId int64, userName string) (bool, error) {
+	added, err := bs.SubscribeMany(ctx, []SubscriptionCategory{subCategory}, userId, chatId, userName)
+	return added > 0, err
+}
+
+func (bs *BoltStorage) SubscribeMany(ctx context.Context, subs []SubscriptionCategory, userId int, chatId int64, userName string) (int, error) {
+	added := 0
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		var res SubscriptionInfo
+		found, err := getBoltSubscriptionInfo(tx, chatId, &res)
+		if err != nil {
+			return err
+		}
+
+		res.ChatId = chatId
+		res.UserName = userName
+		if !found {
+			fmt.Println("User doesn't exist, creating...")
+			res.UserId = userId
+			res.CreateDate = time.Now().UTC().Format(time.RFC1123Z)
+		}
+
+		res.Subscriptions, added = appendNewSubscriptions(res.Subscriptions, subs)
+		return putBoltSubscriptionInfo(tx, res)
+	})
+
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+func (bs *BoltStorage) SetLastTimeCheckedUTC(ctx context.Context, category DouCategory, exp string, checked time.Time) error {
+	return bs.updateCategoryInfo(category, exp, func(c *CategoryInfo) {
+		c.LastTimeChecked = checked.UTC().Format(time.RFC1123Z)
+	})
+}
+
+func (bs *BoltStorage) SetFeedValidators(ctx context.Context, category DouCategory, exp string, validators FeedValidators) error {
+	return bs.updateCategoryInfo(category, exp, func(c *CategoryInfo) {
+		c.FeedValidators = validators
+	})
+}
+
+func (bs *BoltStorage) GetFeedValidators(ctx context.Context, category DouCategory, exp string) (FeedValidators, error) {
+	var doc CategoryInfo
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(categoriesBucket).Get([]byte(categoryKey(category.id, exp)))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &doc)
+	})
+
+	return doc.FeedValidators, err
+}
+
+// updateCategoryInfo keeps fields of the category info which aren't changed by update
+func (bs *BoltStorage) updateCategoryInfo(category DouCategory, exp string, update func(c *CategoryInfo)) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(categoriesBucket)
+		key := []byte(categoryKey(category.id, exp))
+
+		var c CategoryInfo
+		if data := bucket.Get(key); data != nil {
+			if err := json.Unmarshal(data, &c); err != nil {
+				return err
+			}
+		}
+		c.IDCategory, c.NameCategory, c.Experience = IdToDBId(category.id), category.name, IdToDBId(exp)
+		update(&c)
+
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, data)
+	})
+}
+
+func (bs *BoltStorage) GetLastTimeCheckedUTC(ctx context.Context, category DouCategory, exp string) (time.Time, error) {
+	var doc CategoryInfo
+	found := false
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(categoriesBucket).Get([]byte(categoryKey(category.id, exp)))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &doc)
+	})
+
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !found {
+		fmt.Printf("Category %s:id[%s]:exp[%s] wasn't checked yet\n", category.name, category.id, IdToDBId(exp))
+		return time.Time{}, nil
+	}
+
+	tm, err := time.Parse(time.RFC1123Z, doc.LastTimeChecked)
+	if err != nil {
+		fmt.Printf("Error parsing %s to time\n", doc.LastTimeChecked)
+		return time.Time{}, nil
+	}
+
+	return tm, nil
+}
+
+func (bs *BoltStorage) MarkVacancySent(ctx context.Context, chatId int64, vacancyUrl string) (bool, error) {
+	isNew := false
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sentVacanciesBucket)
+		key := []byte(sentVacancyKey(chatId, vacancyUrl))
+		if bucket.Get(key) != nil {
+			return nil
+		}
+
+		isNew = true
+		return bucket.Put(key, []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+
+	return isNew, err
+}
+
+func (bs *BoltStorage) UnmarkVacancySent(ctx context.Context, chatId int64, vacancyUrl string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sentVacanciesBucket).Delete([]byte(sentVacancyKey(chatId, vacancyUrl)))
+	})
+}
+
+func (bs *BoltStorage) RemoveSentVacanciesBefore(ctx context.Context, before time.Time) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sentVacanciesBucket)
+		toDelete := [][]byte{}
+		bucket.ForEach(func(k, v []byte) error {
+			sentDate, err := time.Parse(time.RFC3339, string(v))
+			if err != nil || sentDate.Before(before) {
+				toDelete = append(toDelete, append([]byte(nil), k...))
+			}
+			return nil
+		})
+
+		for _, k := range toDelete {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (bs *BoltStorage) SetDeliveryMode(ctx context.Context, chatId int64, mode string, digestHour int) error {
+	return bs.updateSubscriptionInfo(chatId, func(subInfo *SubscriptionInfo) {
+		subInfo.DeliveryMode = mode
+		subInfo.DigestHour = digestHour
+		subInfo.LastDigestDate = time.Now().UTC().Format(time.RFC1123Z)
+	})
+}
+
+func (bs *BoltStorage) SetLastDigestDate(ctx context.Context, chatId int64, date time.Time) error {
+	return bs.updateSubscriptionInfo(chatId, func(subInfo *SubscriptionInfo) {
+		subInfo.LastDigestDate = date.UTC().Format(time.RFC1123Z)
+	})
+}
+
+func (bs *BoltStorage) SetQuietHours(ctx context.Context, chatId int64, timezone string, quietFrom int, quietTo int) error {
+	return bs.updateSubscriptionInfo(chatId, func(subInfo *SubscriptionInfo) {
+		subInfo.Timezone = timezone
+		subInfo.QuietFrom = quietFrom
+		subInfo.QuietTo = quietTo
+	})
+}
+
+// categories are keyed by their order, so the bucket is recreated on every save
+func (bs *BoltStorage) SaveCategories(ctx context.Context, categories []CategoryRecord) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(categoryListBucket); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		bucket, err := tx.CreateBucket(categoryListBucket)
+		if err != nil {
+			return err
+		}
+
+		for i, category := range categories {
+			category.Order = i
+			data, err := json.Marshal(category)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(fmt.Sprintf("%06d", i)), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (bs *BoltStorage) GetCategories(ctx context.Context) ([]CategoryRecord, error) {
+	res := []CategoryRecord{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(categoryListBucket).ForEach(func(k, v []byte) error {
+			var category CategoryRecord
+			if err := json.Unmarshal(v, &category); err != nil {
+				return err
+			}
+			res = append(res, category)
+			return nil
+		})
+	})
+
+	return res, err
+}
+
+func (bs *BoltStorage) RenameCategory(ctx context.Context, categoryId string, name string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		updated := []SubscriptionInfo{}
+		err := tx.Bucket(subscriptionsBucket).ForEach(func(k, v []byte) error {
+			var subInfo SubscriptionInfo
+			if err := json.Unmarshal(v, &subInfo); err != nil {
+				return err
+			}
+			if renameSubscriptions(subInfo.Subscriptions, categoryId, name) {
+				updated = append(updated, subInfo)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, subInfo := range updated {
+			if err := putBoltSubscriptionInfo(tx, subInfo); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (bs *BoltStorage) SetChannelLink(ctx context.Context, link ChannelLink) error {
+	data, err := json.Marshal(link)
+	if err != nil {
+		return err
+	}
+
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(channelLinksBucket).Put(chatKey(link.ChatId), data)
+	})
+}
+
+func (bs *BoltStorage) GetChannelLink(ctx context.Context, chatId int64) (ChannelLink, error) {
+	link := ChannelLink{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(channelLinksBucket).Get(chatKey(chatId))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &link)
+	})
+
+	return link, err
+}
+
+func (bs *BoltStorage) RemoveChannelLink(ctx context.Context, chatId int64) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(channelLinksBucket).Delete(chatKey(chatId))
+	})
+}
+
+func (bs *BoltStorage) DeactivateChat(ctx context.Context, chatId int64, reason string, date time.Time) error {
+	return bs.updateSubscriptionInfo(chatId, func(subInfo *SubscriptionInfo) {
+		subInfo.Inactive = true
+		subInfo.InactiveReason = reason
+		subInfo.InactiveDate = date.UTC().Format(time.RFC1123Z)
+	})
+}
+
+func (bs *BoltStorage) ReactivateChat(ctx context.Context, chatId int64) (bool, error) {
+	isReactivated := false
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		var subInfo SubscriptionInfo
+		found, err := getBoltSubscriptionInfo(tx, chatId, &subInfo)
+		if err != nil || !found || !subInfo.Inactive {
+			return err
+		}
+
+		subInfo.Inactive, subInfo.InactiveReason, subInfo.InactiveDate = false, "", ""
+		isReactivated = true
+		return putBoltSubscriptionInfo(tx, subInfo)
+	})
+
+	return isReactivated, err
+}
+
+// pending vacancies are kept in a nested bucket per chat, keyed by sequence to preserve order
+func (bs *BoltStorage) AddPendingVacancy(ctx context.Context, chatId int64, vacancy VacancyRecord) error {
+	data, err := json.Marshal(PendingVacancy{ChatId: chatId, Vacancy: vacancy, AddDate: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(pendingBucket).CreateBucketIfNotExists(chatKey(chatId))
+		if err != nil {
+			return err
+		}
+
+		queued := false
+		err = bucket.ForEach(func(k, v []byte) error {
+			var pending PendingVacancy
+			if err := json.Unmarshal(v, &pending); err != nil {
+				return err
+			}
+			queued = queued || pending.Vacancy.Url == vacancy.Url
+			return nil
+		})
+		if err != nil || queued {
+			return err
+		}
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return bucket.Put(key, data)
+	})
+}
+
+func (bs *BoltStorage) GetPendingVacancies(ctx context.Context, chatId int64) ([]VacancyRecord, error) {
+	res := []VacancyRecord{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(pendingBucket).Bucket(chatKey(chatId))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var pending PendingVacancy
+			if err := json.Unmarshal(v, &pending); err != nil {
+				return err
+			}
+			res = append(res, pending.Vacancy)
+			return nil
+		})
+	})
+
+	return res, err
+}
+
+func (bs *BoltStorage) RemovePendingVacancies(ctx context.Context, chatId int64, urls []string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		pending := tx.Bucket(pendingBucket)
+		bucket := pending.Bucket(chatKey(chatId))
+		if bucket == nil {
+			return nil
+		}
+
+		toDelete := [][]byte{}
+		left := 0
+		err := bucket.ForEach(func(k, v []byte) error {
+			var pending PendingVacancy
+			if err := json.Unmarshal(v, &pending); err != nil {
+				return err
+			}
+			if indexOf(urls, pending.Vacancy.Url) == -1 {
+				left++
+			} else {
+				toDelete = append(toDelete, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if left == 0 {
+			return pending.DeleteBucket(chatKey(chatId))
+		}
+		for _, k := range toDelete {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetPendingChats reads only the first vacancy of every chat, sequence keys keep them in the order they were queued
+func (bs *BoltStorage) GetPendingChats(ctx context.Context) (map[int64]time.Time, error) {
+	res := map[int64]time.Time{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		pending := tx.Bucket(pendingBucket)
+		return pending.ForEachBucket(func(k []byte) error {
+			chatId, err := strconv.ParseInt(string(k), 10, 64)
+			if err != nil {
+				return err
+			}
+
+			_, v := pending.Bucket(k).Cursor().First()
+			if v == nil {
+				return nil
+			}
+			var oldest PendingVacancy
+			if err := json.Unmarshal(v, &oldest); err != nil {
+				return err
+			}
+			res[chatId] = oldest.AddDate
+			return nil
+		})
+	})
+
+	return res, err
+}
+
+// outbox messages are keyed by their ids which start with creation time, so iteration returns the oldest ones first,
+// messages queued before that were keyed by sequence numbers which sort before any id
+func (bs *BoltStorage) EnqueueMessage(ctx context.Context, msg OutboxMessage) error {
+	if msg.ID == "" {
+		msg.ID = newOutboxId()
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+		if bucket.Get([]byte(msg.ID)) != nil {
+			return nil
+		}
+		return bucket.Put([]byte(msg.ID), data)
+	})
+}
+
+func (bs *BoltStorage) GetDueMessages(ctx context.Context, now time.Time, limit int) ([]OutboxMessage, error) {
+	res := []OutboxMessage{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(outboxBucket).Cursor()
+		for k, v := c.First(); k != nil && len(res) < limit; k, v = c.Next() {
+			var msg OutboxMessage
+			if err := json.Unmarshal(v, &msg); err != nil {
+				return err
+			}
+			if !msg.NextAttempt.After(now) {
+				res = append(res, msg)
+			}
+		}
+		return nil
+	})
+
+	return res, err
+}
+
+func (bs *BoltStorage) RescheduleMessage(ctx context.Context, id string, attempts int, nextAttempt time.Time) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("Outbox message %s wasn't found", id)
+		}
+
+		var msg OutboxMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return err
+		}
+		msg.Attempts = attempts
+		msg.NextAttempt = nextAttempt
+
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), data)
+	})
+}
+
+func (bs *BoltStorage) RemoveMessage(ctx context.Context, id string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).Delete([]byte(id))
+	})
+}
+
+func (bs *BoltStorage) CountMessages(ctx context.Context) (int, error) {
+	count := 0
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(outboxBucket).Stats().KeyN
+		return nil
+	})
+
+	return count, err
+}
+
+// Ping makes sure database file is still open
+func (bs *BoltStorage) Ping(ctx context.Context) error {
+	return bs.db.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+func (bs *BoltStorage) updateSubscriptionInfo(chatId int64, update func(subInfo *SubscriptionInfo)) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		var subInfo SubscriptionInfo
+		found, err := getBoltSubscriptionInfo(tx, chatId, &subInfo)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("Subscription info for chat %d wasn't found", chatId)
+		}
+
+		update(&subInfo)
+		return putBoltSubscriptionInfo(tx, subInfo)
+	})
+}
+
+func getBoltSubscriptionInfo(tx *bolt.Tx, chatId int64, subInfo *SubscriptionInfo) (bool, error) {
+	data := tx.Bucket(subscriptionsBucket).Get(chatKey(chatId))
+	if data == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, subInfo)
+}
+
+func putBoltSubscriptionInfo(tx *bolt.Tx, subInfo SubscriptionInfo) error {
+	data, err := json.Marshal(subInfo)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(subscriptionsBucket).Put(chatKey(subInfo.ChatId), data)
+}
+
+func chatKey(chatId int64) []byte {
+	return []byte(strconv.FormatInt(chatId, 10))
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func createTestBoltStorage(t *testing.T) *BoltStorage {
+	t.Setenv("BOLT_PATH", filepath.Join(t.TempDir(), "dou.db"))
+	storage, err := CreateBoltStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.Close(context.Background()) })
+	return storage
+}
+
+func TestBoltMigratesLegacySubscriptions(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "dou.db")
+	t.Setenv("BOLT_PATH", path)
+
+	// database written before subscriptions got ids and were keyed by chat
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := SubscriptionInfo{
+		UserId:        7,
+		ChatId:        -100,
+		UserName:      "user",
+		Subscriptions: []SubscriptionCategory{{IDCategory: "Java", NameCategory: "Java", Experience: "1-3"}},
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucket(metaBucket)
+		if err != nil {
+			return err
+		}
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, 3)
+		if err := meta.Put(schemaVersionKey, v); err != nil {
+			return err
+		}
+
+		for _, name := range [][]byte{categoriesBucket, sentVacanciesBucket} {
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		pending, err := tx.CreateBucket(pendingBucket)
+		if err != nil {
+			return err
+		}
+		chatPending, err := pending.CreateBucket(chatKey(legacy.ChatId))
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(VacancyRecord{Url: "https://jobs.dou.ua/1"})
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, 1)
+		if err := chatPending.Put(key, data); err != nil {
+			return err
+		}
+
+		subscriptions, err := tx.CreateBucket(subscriptionsBucket)
+		if err != nil {
+			return err
+		}
+		data, err = json.Marshal(legacy)
+		if err != nil {
+			return err
+		}
+		return subscriptions.Put([]byte(strconv.Itoa(legacy.UserId)), data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	storage, err := CreateBoltStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close(ctx)
+
+	err = storage.db.View(func(tx *bolt.Tx) error {
+		version := binary.BigEndian.Uint64(tx.Bucket(metaBucket).Get(schemaVersionKey))
+		if version != uint64(len(boltMigrations)) {
+			t.Errorf("expected schema version %d, got %d", len(boltMigrations), version)
+		}
+		if tx.Bucket(subscriptionsBucket).Get([]byte(strconv.Itoa(legacy.UserId))) != nil {
+			t.Error("subscription is still stored under user id")
+		}
+		for _, name := range [][]byte{outboxBucket, categoryListBucket, channelLinksBucket} {
+			if tx.Bucket(name) == nil {
+				t.Errorf("bucket %s wasn't created", name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subInfo, err := storage.GetSubscriptionInfo(ctx, legacy.ChatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subInfo.Subscriptions) != 1 || subInfo.Subscriptions[0].ID == "" {
+		t.Fatalf("subscription didn't get an id: %+v", subInfo.Subscriptions)
+	}
+
+	subs, err := storage.GetAllSubscribers(ctx, "Java", "Java", "1-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 1 || subs[0].ChatId != legacy.ChatId {
+		t.Errorf("expected migrated subscriber, got %+v", subs)
+	}
+
+	vacancies, err := storage.GetPendingVacancies(ctx, legacy.ChatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vacancies) != 1 || vacancies[0].Url != "https://jobs.dou.ua/1" {
+		t.Errorf("pending vacancy wasn't migrated: %+v", vacancies)
+	}
+}
+
+func TestBoltSubscribeAndUnsubscribe(t *testing.T) {
+	ctx := context.Background()
+	storage := createTestBoltStorage(t)
+	java := DouCategory{id: "Java", name: "Java"}
+	subs := []SubscriptionCategory{CreateSubscriptionCategory(java, "1-3"), CreateSubscriptionCategory(java, "3-5")}
+
+	added, err := storage.SubscribeMany(ctx, subs, 1, 1, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 {
+		t.Fatalf("expected 2 subscriptions to be added, got %d", added)
+	}
+	if added, err := storage.SubscribeMany(ctx, subs[:1], 1, 1, "user"); added != 0 || err != nil {
+		t.Errorf("duplicate subscription was added: %d, %v", added, err)
+	}
+
+	if ok, err := storage.UnsubscribeUser(ctx, subs[0].ID, 1); !ok || err != nil {
+		t.Fatalf("subscription wasn't removed: %v", err)
+	}
+	if ok, err := storage.UnsubscribeUser(ctx, subs[0].ID, 1); ok || err != nil {
+		t.Errorf("removed subscription was found again: %v", err)
+	}
+
+	subInfo, err := storage.GetSubscriptionInfo(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subInfo.Subscriptions) != 1 || subInfo.Subscriptions[0].ID != subs[1].ID {
+		t.Errorf("unexpected subscriptions %+v", subInfo.Subscriptions)
+	}
+}
+
+func TestBoltCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	storage := createTestBoltStorage(t)
+	java := DouCategory{id: "Java", name: "Java"}
+
+	checked, err := storage.GetLastTimeCheckedUTC(ctx, java, "1-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checked.IsZero() {
+		t.Errorf("expected zero time for unchecked category, got %v", checked)
+	}
+
+	now := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := storage.SetLastTimeCheckedUTC(ctx, java, "1-3", now); err != nil {
+		t.Fatal(err)
+	}
+	checked, err = storage.GetLastTimeCheckedUTC(ctx, java, "1-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checked.Equal(now) {
+		t.Errorf("expected %v, got %v", now, checked)
+	}
+
+	other, err := storage.GetLastTimeCheckedUTC(ctx, java, "3-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !other.IsZero() {
+		t.Errorf("checkpoint leaked to other experience: %v", other)
+	}
+}
+
+func TestBoltPendingVacancies(t *testing.T) {
+	ctx := context.Background()
+	storage := createTestBoltStorage(t)
+
+	for _, url := range []string{"https://jobs.dou.ua/1", "https://jobs.dou.ua/2"} {
+		if err := storage.AddPendingVacancy(ctx, -100, VacancyRecord{Url: url}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chats, err := storage.GetPendingChats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if since, ok := chats[-100]; len(chats) != 1 || !ok || since.IsZero() {
+		t.Errorf("unexpected pending chats %v", chats)
+	}
+
+	vacancies, err := storage.GetPendingVacancies(ctx, -100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vacancies) != 2 || vacancies[0].Url != "https://jobs.dou.ua/1" || vacancies[1].Url != "https://jobs.dou.ua/2" {
+		t.Errorf("unexpected pending vacancies %+v", vacancies)
+	}
+
+	if err := storage.RemovePendingVacancies(ctx, -100, []string{"https://jobs.dou.ua/1"}); err != nil {
+		t.Fatal(err)
+	}
+	vacancies, err = storage.GetPendingVacancies(ctx, -100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vacancies) != 1 || vacancies[0].Url != "https://jobs.dou.ua/2" {
+		t.Errorf("unexpected pending vacancies after removal %+v", vacancies)
+	}
+	if err := storage.RemovePendingVacancies(ctx, -100, []string{"https://jobs.dou.ua/2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	chats, err = storage.GetPendingChats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chats) != 0 {
+		t.Errorf("chats are still pending after removal: %v", chats)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+const (
+	// breakerThreshold is the amount of throttled or failed requests in a row which opens the breaker
+	breakerThreshold = 5
+	breakerBaseDelay = 30 * time.Second
+	breakerMaxDelay  = 30 * time.Minute
+)
+
+var errCircuitOpen = errors.New("DOU circuit breaker is open")
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// circuitBreaker stops requests to DOU after it keeps answering with 429 or 5xx or can't be reached,
+// once the delay passes a single probe request is let through to check if DOU recovered
+type circuitBreaker struct {
+	lock      sync.Mutex
+	state     breakerState
+	failures  int
+	delay     time.Duration
+	openUntil time.Time
+	probing   bool
+	// generation changes with every state, so reports of requests let through before it changed are ignored
+	generation uint64
+}
+
+func createCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{delay: breakerBaseDelay}
+}
+
+// Allow returns errCircuitOpen while requests have to be held back,
+// otherwise the generation which the request has to be reported with
+func (cb *circuitBreaker) Allow() (uint64, error) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		if time.Now().Before(cb.openUntil) {
+			return 0, fmt.Errorf("%w until %s", errCircuitOpen, cb.openUntil.Format(time.RFC1123Z))
+		}
+		cb.setState(breakerHalfOpen)
+		cb.probing = true
+	case breakerHalfOpen:
+		if cb.probing {
+			return 0, fmt.Errorf("%w, probe request is in progress", errCircuitOpen)
+		}
+		cb.probing = true
+	}
+	return cb.generation, nil
+}
+
+// IsOpen tells if requests are held back now without letting a probe through
+func (cb *circuitBreaker) IsOpen() bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	return cb.state == breakerOpen && time.Now().Before(cb.openUntil)
+}
+
+// Report records the status code of a request let through by Allow in generation, zero status means the request failed before DOU answered.
+// Late reports of requests let through before the breaker opened or the probe was sent are ignored
+func (cb *circuitBreaker) Report(generation uint64, status int, retryAfter time.Duration) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if generation != cb.generation {
+		return
+	}
+	cb.probing = false
+
+	if status != 0 && status != http.StatusTooManyRequests && status < http.StatusInternalServerError {
+		if cb.state != breakerClosed {
+			fmt.Println("DOU recovered")
+		}
+		cb.setState(breakerClosed)
+		cb.failures = 0
+		cb.delay = breakerBaseDelay
+		return
+	}
+
+	cb.failures++
+	if cb.state == breakerClosed && cb.failures < breakerThreshold && retryAfter == 0 {
+		return
+	}
+
+	delay := cb.delay
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	cb.openUntil = time.Now().Add(delay)
+	cb.delay *= 2
+	if cb.delay > breakerMaxDelay {
+		cb.delay = breakerMaxDelay
+	}
+	if status == 0 {
+		fmt.Printf("DOU can't be reached, pausing requests for %v\n", delay)
+	} else {
+		fmt.Printf("DOU answered with %d, pausing requests for %v\n", status, delay)
+	}
+	cb.setState(breakerOpen)
+}
+
+// Status describes state of the breaker for the status endpoint
+func (cb *circuitBreaker) Status() string {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.state == breakerOpen {
+		return fmt.Sprintf("%s until %s after %d failures", cb.state, cb.openUntil.Format(time.RFC1123Z), cb.failures)
+	}
+	return cb.state.String()
+}
+
+func (cb *circuitBreaker) setState(state breakerState) {
+	if cb.state != state {
+		fmt.Printf("DOU circuit breaker is %s\n", state)
+		cb.generation++
+	}
+	cb.state = state
+	breakerStateGauge.Set(float64(state))
+}
+
+// parseRetryAfter reads Retry-After header given either in seconds or as a date
+func parseRetryAfter(headers *http.Header) time.Duration {
+	if headers == nil {
+		return 0
+	}
+
+	value := headers.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
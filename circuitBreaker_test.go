@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpensAndProbes(t *testing.T) {
+	cb := createCircuitBreaker()
+	for i := 0; i < breakerThreshold; i++ {
+		generation, err := cb.Allow()
+		if err != nil {
+			t.Fatalf("request %d was held back: %v", i, err)
+		}
+		cb.Report(generation, http.StatusBadGateway, 0)
+	}
+
+	if _, err := cb.Allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("expected breaker to be open, got %v", err)
+	}
+
+	cb.openUntil = time.Now()
+	probe, err := cb.Allow()
+	if err != nil {
+		t.Fatalf("probe wasn't let through: %v", err)
+	}
+	if _, err := cb.Allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("second probe was let through: %v", err)
+	}
+
+	cb.Report(probe, http.StatusOK, 0)
+	if _, err := cb.Allow(); err != nil || cb.Status() != "closed" {
+		t.Errorf("breaker wasn't closed after successful probe: %v", err)
+	}
+}
+
+func TestCircuitBreakerHonoursRetryAfter(t *testing.T) {
+	cb := createCircuitBreaker()
+	headers := http.Header{"Retry-After": []string{"120"}}
+	retryAfter := parseRetryAfter(&headers)
+	if retryAfter != 2*time.Minute {
+		t.Fatalf("expected 2m, got %v", retryAfter)
+	}
+
+	generation, _ := cb.Allow()
+	cb.Report(generation, http.StatusTooManyRequests, retryAfter)
+	if !cb.IsOpen() || time.Until(cb.openUntil) < time.Minute {
+		t.Errorf("breaker didn't wait for Retry-After: %s", cb.Status())
+	}
+}
+
+func TestCircuitBreakerIgnoresLateReports(t *testing.T) {
+	cb := createCircuitBreaker()
+	late, _ := cb.Allow()
+	for i := 0; i < breakerThreshold; i++ {
+		generation, _ := cb.Allow()
+		cb.Report(generation, 0, 0)
+	}
+	if !cb.IsOpen() {
+		t.Fatalf("breaker wasn't opened by unreachable DOU: %s", cb.Status())
+	}
+
+	openUntil, delay := cb.openUntil, cb.delay
+	cb.Report(late, http.StatusBadGateway, 0)
+	if cb.openUntil != openUntil || cb.delay != delay {
+		t.Errorf("late failure changed the pause: %v until %v", cb.delay, cb.openUntil)
+	}
+
+	cb.openUntil = time.Now()
+	probe, err := cb.Allow()
+	if err != nil {
+		t.Fatalf("probe wasn't let through: %v", err)
+	}
+	cb.Report(late, http.StatusOK, 0)
+	if cb.Status() != "half-open" {
+		t.Errorf("late success closed the breaker without probe: %s", cb.Status())
+	}
+	if _, err := cb.Allow(); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("late report let another probe through: %v", err)
+	}
+
+	cb.Report(probe, 0, 0)
+	if !cb.IsOpen() {
+		t.Errorf("failed probe didn't open the breaker: %s", cb.Status())
+	}
+}
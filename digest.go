@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
+)
+
+const (
+	instantMode = ""
+	hourlyMode  = "hourly"
+	dailyMode   = "daily"
+	weeklyMode  = "weekly"
+
+	defaultTimezone       = "Europe/Kyiv"
+	checkDigestsInterval  = time.Minute
+	maxDigestMessageRunes = 4000
+	quietHoursOff         = "Вимкнути"
+)
+
+var deliveryModes = []struct {
+	name string
+	mode string
+}{
+	{"Одразу", instantMode},
+	{"Щогодини", hourlyMode},
+	{"Щодня", dailyMode},
+	{"Щотижня", weeklyMode},
+}
+
+var digestHourOptions = []string{"08:00", "09:00", "10:00", "12:00", "18:00", "20:00"}
+var timezoneOptions = []string{"Europe/Kyiv", "Europe/Warsaw", "Europe/Berlin", "Europe/Lisbon", "America/New_York", "UTC"}
+var quietHoursOptions = []string{"22-8", "23-7", "0-9", quietHoursOff}
+
+// findDeliveryMode looks mode up by its id from buttons or by name typed by user
+func findDeliveryMode(value string, isId bool) (string, error) {
+	for _, mode := range deliveryModes {
+		if (isId && mode.mode == value) || (!isId && mode.name == value) {
+			return mode.mode, nil
+		}
+	}
+	return "", fmt.Errorf("Delivery mode `%s` wasn't found", value)
+}
+
+func isDigestMode(mode string) bool {
+	return mode == hourlyMode || mode == dailyMode || mode == weeklyMode
+}
+
+func defaultLocation() *time.Location {
+	loc, err := time.LoadLocation(defaultTimezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func userLocation(subInfo SubscriptionInfo) *time.Location {
+	if subInfo.Timezone == "" {
+		return defaultLocation()
+	}
+
+	loc, err := time.LoadLocation(subInfo.Timezone)
+	if err != nil {
+		return defaultLocation()
+	}
+	return loc
+}
+
+// inQuietHours checks if user's local time is inside quiet window, equal bounds mean it is disabled
+func inQuietHours(subInfo SubscriptionInfo, now time.Time) bool {
+	if subInfo.QuietFrom == subInfo.QuietTo {
+		return false
+	}
+
+	hour := now.In(userLocation(subInfo)).Hour()
+	if subInfo.QuietFrom < subInfo.QuietTo {
+		return hour >= subInfo.QuietFrom && hour < subInfo.QuietTo
+	}
+	return hour >= subInfo.QuietFrom || hour < subInfo.QuietTo
+}
+
+// shouldHoldVacancies tells if vacancies have to be queued instead of being sent right away
+func shouldHoldVacancies(subInfo SubscriptionInfo, now time.Time) bool {
+	return isDigestMode(subInfo.DeliveryMode) || inQuietHours(subInfo, now)
+}
+
+// nextDigestTime returns the first digest slot after both the last digest and the oldest queued vacancy,
+// so vacancies queued after an empty slot wait for the next one,
+// daily and weekly digests are sent at the chosen local hour, weekly ones on mondays
+func nextDigestTime(subInfo SubscriptionInfo, pendingSince time.Time, loc *time.Location) time.Time {
+	last, err := time.Parse(time.RFC1123Z, subInfo.LastDigestDate)
+	if err != nil || last.Before(pendingSince) {
+		last = pendingSince
+	}
+	last = last.In(loc)
+
+	if subInfo.DeliveryMode == hourlyMode {
+		return time.Date(last.Year(), last.Month(), last.Day(), last.Hour(), 0, 0, 0, loc).Add(time.Hour)
+	}
+
+	next := time.Date(last.Year(), last.Month(), last.Day(), subInfo.DigestHour, 0, 0, 0, loc)
+	for !next.After(last) || (subInfo.DeliveryMode == weeklyMode && next.Weekday() != time.Monday) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// sendDigests flushes queued vacancies when digest time comes or quiet hours end
+func sendDigests(ctx context.Context, tb *TelegramBot) {
+	ticker := time.NewTicker(checkDigestsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		chats, err := tb.storage.GetPendingChats(ctx)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		for chatId, pendingSince := range chats {
+			subInfo, err := tb.storage.GetSubscriptionInfo(ctx, chatId)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			now := time.Now()
+			if subInfo.Inactive || inQuietHours(subInfo, now) {
+				continue
+			}
+			if isDigestMode(subInfo.DeliveryMode) && now.Before(nextDigestTime(subInfo, pendingSince, userLocation(subInfo))) {
+				continue
+			}
+
+			sendDigest(ctx, tb, subInfo, now)
+		}
+	}
+}
+
+// sendDigest removes queued vacancies only after the message with them is queued, so a storage failure
+// leaves them for the next attempt instead of losing them
+func sendDigest(ctx context.Context, tb *TelegramBot, subInfo SubscriptionInfo, now time.Time) {
+	vacancies, err := tb.storage.GetPendingVacancies(ctx, subInfo.ChatId)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if len(vacancies) > 0 {
+		fmt.Printf("Sending digest with %d vacancies to subscriber(%s)\n", len(vacancies), subInfo.UserName)
+		for _, msg := range formatDigestMessages(vacancies) {
+			if err := tb.enqueueMessage(ctx, subInfo.ChatId, msg.text, true); err != nil {
+				fmt.Println(err)
+				return
+			}
+			if err := tb.storage.RemovePendingVacancies(ctx, subInfo.ChatId, msg.urls); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+	}
+
+	if err := tb.storage.SetLastDigestDate(ctx, subInfo.ChatId, now); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// digestMessage is a part of digest with urls of vacancies it contains
+type digestMessage struct {
+	text string
+	urls []string
+}
+
+// formatDigestMessages groups vacancies into as few messages as telegram message limit allows
+func formatDigestMessages(vacancies []VacancyRecord) []digestMessage {
+	header := fmt.Sprintf("📬 <b>Нові вакансії: %d</b>\n\n", len(vacancies))
+	res := []digestMessage{}
+	msg := digestMessage{text: header}
+	for _, record := range vacancies {
+		vacancy := record.ToVacancy()
+		entry := fmt.Sprintf("➡️<b>%s</b>", formatString(vacancy.position))
+		details := []string{}
+		if vacancy.company != "" {
+			details = append(details, vacancy.company)
+		}
+		if vacancy.salary.IsStated() {
+			details = append(details, vacancy.salary.String())
+		}
+		if len(details) > 0 {
+			entry += fmt.Sprintf(" (%s)", formatString(strings.Join(details, ", ")))
+		}
+		entry += fmt.Sprintf("\n<i>%s</i>\n%s\n\n", formatString(vacancy.categoryName), vacancy.url)
+
+		if len([]rune(msg.text))+len([]rune(entry)) > maxDigestMessageRunes && msg.text != header {
+			res = append(res, msg)
+			msg = digestMessage{}
+		}
+		msg.text += entry
+		msg.urls = append(msg.urls, record.Url)
+	}
+	return append(res, msg)
+}
+
+// parseQuietHours parses windows like `23-7` or `23:00-07:00`
+func parseQuietHours(text string) (int, int, error) {
+	if text == quietHoursOff {
+		return 0, 0, nil
+	}
+
+	bounds := strings.Split(text, "-")
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("Quiet hours `%s` aren't valid", text)
+	}
+
+	res := [2]int{}
+	for i, bound := range bounds {
+		bound = strings.TrimSuffix(strings.TrimSpace(bound), ":00")
+		hour, err := strconv.Atoi(bound)
+		if err != nil || hour < 0 || hour > 23 {
+			return 0, 0, fmt.Errorf("Quiet hours `%s` aren't valid", text)
+		}
+		res[i] = hour
+	}
+	return res[0], res[1], nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextDigestTime(t *testing.T) {
+	loc := defaultLocation()
+	// 2023-03-15 is wednesday
+	last := time.Date(2023, time.March, 15, 10, 30, 0, 0, loc)
+
+	tests := []struct {
+		mode     string
+		hour     int
+		expected time.Time
+	}{
+		{hourlyMode, 0, time.Date(2023, time.March, 15, 11, 0, 0, 0, loc)},
+		{dailyMode, 18, time.Date(2023, time.March, 15, 18, 0, 0, 0, loc)},
+		{dailyMode, 9, time.Date(2023, time.March, 16, 9, 0, 0, 0, loc)},
+		{weeklyMode, 9, time.Date(2023, time.March, 20, 9, 0, 0, 0, loc)},
+	}
+
+	for _, test := range tests {
+		subInfo := SubscriptionInfo{DeliveryMode: test.mode, DigestHour: test.hour, LastDigestDate: last.Format(time.RFC1123Z)}
+		if next := nextDigestTime(subInfo, time.Time{}, loc); !next.Equal(test.expected) {
+			t.Errorf("%s at %d: expected %v, got %v", test.mode, test.hour, test.expected, next)
+		}
+	}
+}
+
+func TestVacancyQueuedAfterEmptySlotWaitsForNextSlot(t *testing.T) {
+	loc := defaultLocation()
+	// the last digest went out on monday 2023-03-13, nothing was queued for the slot on 2023-03-20
+	subInfo := SubscriptionInfo{DeliveryMode: weeklyMode, DigestHour: 9, LastDigestDate: time.Date(2023, time.March, 13, 9, 0, 0, 0, loc).Format(time.RFC1123Z)}
+	queued := time.Date(2023, time.March, 22, 14, 0, 0, 0, loc)
+
+	next := nextDigestTime(subInfo, queued, loc)
+	if expected := time.Date(2023, time.March, 27, 9, 0, 0, 0, loc); !next.Equal(expected) {
+		t.Errorf("expected %v, got %v", expected, next)
+	}
+	if now := queued.Add(time.Minute); !now.Before(next) {
+		t.Errorf("vacancy queued on %v would be sent right away at %v", queued, now)
+	}
+
+	// vacancies queued before a missed slot are still sent right away
+	queued = time.Date(2023, time.March, 15, 14, 0, 0, 0, loc)
+	if next := nextDigestTime(subInfo, queued, loc); !next.Equal(time.Date(2023, time.March, 20, 9, 0, 0, 0, loc)) {
+		t.Errorf("expected digest on the first slot after %v, got %v", queued, next)
+	}
+}
+
+func TestFormatDigestMessagesSplitsLongDigests(t *testing.T) {
+	vacancies := []VacancyRecord{}
+	for i := 0; i < 100; i++ {
+		vacancies = append(vacancies, VacancyRecord{Position: "Golang Developer", CategoryName: "Golang", Url: "https://jobs.dou.ua/companies/a/vacancies/1/"})
+	}
+
+	messages := formatDigestMessages(vacancies)
+	if len(messages) < 2 {
+		t.Fatalf("expected digest to be split, got %d messages", len(messages))
+	}
+	urls := 0
+	for _, msg := range messages {
+		if len([]rune(msg.text)) > maxDigestMessageRunes {
+			t.Errorf("message is too long: %d", len([]rune(msg.text)))
+		}
+		urls += len(msg.urls)
+	}
+	if urls != len(vacancies) {
+		t.Errorf("expected every vacancy to belong to a message, got %d urls", urls)
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	loc := defaultLocation()
+	tests := []struct {
+		from, to int
+		hour     int
+		expected bool
+	}{
+		{0, 0, 3, false},
+		{23, 7, 3, true},
+		{23, 7, 23, true},
+		{23, 7, 7, false},
+		{23, 7, 12, false},
+		{1, 9, 8, true},
+		{1, 9, 0, false},
+	}
+
+	for _, test := range tests {
+		subInfo := SubscriptionInfo{Timezone: defaultTimezone, QuietFrom: test.from, QuietTo: test.to}
+		now := time.Date(2023, time.March, 15, test.hour, 30, 0, 0, loc)
+		if res := inQuietHours(subInfo, now); res != test.expected {
+			t.Errorf("%d-%d at %d: expected %v, got %v", test.from, test.to, test.hour, test.expected, res)
+		}
+	}
+}
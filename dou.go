@@ -1,22 +1,53 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/gocolly/colly"
 )
 
 type DouVacancy struct {
 	url          string
 	name         string
+	position     string
+	company      string
+	salary       DouSalary
+	cities       []string
+	remote       bool
+	relocation   bool
+	description  string
+	snippet      string
 	experience   string
 	categoryId   string
 	categoryName string
 }
 
+// vacancyHandoff is a vacancy passed to the bot, handled receives nil once it is queued for every subscriber
+type vacancyHandoff struct {
+	vacancy DouVacancy
+	handled chan<- error
+}
+
+// DouSalary is a salary range, zero min or max means the bound isn't stated
+type DouSalary struct {
+	min      int
+	max      int
+	currency string
+}
+
 type DouCategory struct {
 	url  string
 	name string
@@ -24,101 +55,395 @@ type DouCategory struct {
 }
 
 type DouWorker struct {
-	storage           Storage
-	categories        []DouCategory
+	storage      Storage
+	baseUrl      string
+	newCollector func() *colly.Collector
+	// categories is replaced as a whole on every refresh, so readers never see a partially updated list
+	categories        atomic.Pointer[[]DouCategory]
 	experienceFilters map[string]string
-	newVacancyChan    chan DouVacancy
+	newVacancyChan    chan vacancyHandoff
+	// lookBack is how far back vacancies are sent for categories which weren't checked yet
+	lookBack time.Duration
+	workers  int
+	// limiter is shared by all workers, DOU is the only host they request
+	limiter *rateLimiter
+	breaker *circuitBreaker
 }
 
+var (
+	salaryRegexp    = regexp.MustCompile(`^(?i)(від|до)?\s*([$€₴])?\s*(\d[\d\s]*)(?:\s*[–—-]\s*[$€₴]?\s*(\d[\d\s]*))?\s*(грн|₴|\$|€)?$`)
+	currencySymbols = map[string]string{"$": "USD", "€": "EUR", "₴": "UAH", "грн": "UAH"}
+)
+
 const (
-	checkVacanciesInterval = 10
-	feedUrl                = "https://jobs.dou.ua/vacancies/feeds/?category="
-	categoriesUrl          = "https://jobs.dou.ua/vacancies/"
+	snippetLength             = 200
+	checkVacanciesInterval    = 10
+	defaultDouUrl             = "https://jobs.dou.ua"
+	feedPath                  = "/vacancies/feeds/?category="
+	categoriesPath            = "/vacancies/"
+	defaultLookBack           = 24 * time.Hour
+	refreshCategoriesInterval = 6 * time.Hour
+	categoriesRetryBaseDelay  = 30 * time.Second
+	categoriesRetryMaxDelay   = 30 * time.Minute
+	defaultScrapWorkers       = 4
+	feedRequestInterval       = 500 * time.Millisecond
+	feedRequestJitter         = 250 * time.Millisecond
 )
 
-func CreateDouWorker(storage Storage) *DouWorker {
+// CreateDouWorker creates worker scraping DOU at baseUrl (defaultDouUrl when empty),
+// newCollector allows to replace collectors used for every request (createCollector sharing one transport sized for workers when nil),
+// lookBack limits vacancies sent for categories seen for the first time (defaultLookBack when zero),
+// workers is the amount of feeds scraped in parallel (defaultScrapWorkers when zero)
+func CreateDouWorker(storage Storage, baseUrl string, newCollector func() *colly.Collector, lookBack time.Duration, workers int) *DouWorker {
+	if baseUrl == "" {
+		baseUrl = defaultDouUrl
+	}
+	if lookBack <= 0 {
+		lookBack = defaultLookBack
+	}
+	if workers <= 0 {
+		workers = defaultScrapWorkers
+	}
+	if newCollector == nil {
+		transport := createDouTransport(workers)
+		newCollector = func() *colly.Collector {
+			return createCollector(transport)
+		}
+	}
+
 	return &DouWorker{
 		storage:        storage,
-		newVacancyChan: make(chan DouVacancy),
+		baseUrl:        strings.TrimSuffix(baseUrl, "/"),
+		newCollector:   newCollector,
+		newVacancyChan: make(chan vacancyHandoff),
+		lookBack:       lookBack,
+		workers:        workers,
+		limiter:        createRateLimiter(feedRequestInterval, feedRequestJitter),
+		breaker:        createCircuitBreaker(),
+		experienceFilters: map[string]string{
+			"< 1 року":         "0-1",
+			"1…3 роки":         "1-3",
+			"3…5 років":        "3-5",
+			"5+ років":         "5plus",
+			"Будь-який досвід": "",
+		},
+	}
+}
+
+// Run scraps categories and starts checking feeds and refreshing categories until ctx is cancelled,
+// when DOU is down it starts with categories stored by the last successful refresh.
+// newVacancyChan is closed after the last vacancy is handed off
+func (dw *DouWorker) Run(ctx context.Context) error {
+	err := refreshCategories(ctx, dw)
+	if err != nil {
+		fmt.Printf("Scraping categories failed, using stored ones: %v\n", err)
+		if err := loadStoredCategories(ctx, dw); err != nil {
+			return err
+		}
+	}
+
+	go scrapVacancies(ctx, dw)
+	go refreshCategoriesPeriodically(ctx, dw, err == nil)
+	return nil
+}
+
+func loadStoredCategories(ctx context.Context, dw *DouWorker) error {
+	records, err := dw.storage.GetCategories(ctx)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("No categories were stored yet")
+	}
+
+	categories := []DouCategory{}
+	for _, record := range records {
+		categories = append(categories, dw.newCategory(record.ID, record.Name))
+	}
+	dw.categories.Store(&categories)
+	return nil
+}
+
+// Categories returns the current snapshot of categories, it must not be modified
+func (dw *DouWorker) Categories() []DouCategory {
+	if categories := dw.categories.Load(); categories != nil {
+		return *categories
+	}
+	return nil
+}
+
+func (dw *DouWorker) newCategory(id string, name string) DouCategory {
+	return DouCategory{
+		id:   id,
+		name: name,
+		url:  dw.baseUrl + feedPath + url.QueryEscape(id),
+	}
+}
+
+// refreshCategoriesPeriodically refreshes categories on schedule, failed refreshes are retried with backoff
+func refreshCategoriesPeriodically(ctx context.Context, dw *DouWorker, refreshed bool) {
+	retryDelay := categoriesRetryBaseDelay
+	wait := refreshCategoriesInterval
+	if !refreshed {
+		wait, retryDelay = retryDelay, 2*retryDelay
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if err := refreshCategories(ctx, dw); err != nil {
+			fmt.Printf("Refreshing categories failed, retrying in %v: %v\n", retryDelay, err)
+			wait = retryDelay
+			retryDelay *= 2
+			if retryDelay > categoriesRetryMaxDelay {
+				retryDelay = categoriesRetryMaxDelay
+			}
+			continue
+		}
+		wait, retryDelay = refreshCategoriesInterval, categoriesRetryBaseDelay
 	}
 }
 
-func (dw *DouWorker) Run() error {
-	res, err := scrapCategories()
+// refreshCategories scraps categories, updates names in subscriptions to categories which were renamed
+// or aren't stored yet, so subscriptions made before the list was stored are fixed too,
+// then stores the list and publishes it as the new snapshot
+func refreshCategories(ctx context.Context, dw *DouWorker) error {
+	categories, err := scrapCategories(ctx, dw)
+	if err != nil {
+		return err
+	}
+	if len(categories) == 0 {
+		return fmt.Errorf("No categories were found on DOU")
+	}
+
+	stored, err := dw.storage.GetCategories(ctx)
 	if err != nil {
 		return err
 	}
+	storedNames := map[string]string{}
+	for _, record := range stored {
+		storedNames[record.ID] = record.Name
+	}
+
+	records := []CategoryRecord{}
+	for _, category := range categories {
+		if name, ok := storedNames[category.id]; !ok || name != category.name {
+			if err := dw.storage.RenameCategory(ctx, category.id, category.name); err != nil {
+				return err
+			}
+		}
+		records = append(records, CategoryRecord{ID: category.id, Name: category.name})
+	}
 
-	dw.categories = res
-	dw.experienceFilters = map[string]string{
-		"< 1 року":         "0-1",
-		"1…3 роки":         "1-3",
-		"3…5 років":        "3-5",
-		"5+ років":         "5plus",
-		"Будь-який досвід": "",
+	if err := dw.storage.SaveCategories(ctx, records); err != nil {
+		return err
 	}
-	go scrapVacancies(dw)
+	dw.categories.Store(&categories)
 	return nil
 }
 
-func scrapVacancies(dw *DouWorker) {
+// scrapVacancies sweeps all feeds every checkVacanciesInterval, feeds are scraped in parallel by dw.workers
+func scrapVacancies(ctx context.Context, dw *DouWorker) {
+	defer close(dw.newVacancyChan)
+
 	ticker := time.NewTicker(checkVacanciesInterval * time.Minute)
+	defer ticker.Stop()
 	for {
-		for _, category := range dw.categories {
-			for _, v := range dw.experienceFilters {
-				lastTimeChecked := dw.storage.GetLastTimeCheckedUTC(category, v)
-				if err := scrapCategory(dw, category, v, lastTimeChecked); err != nil {
+		sweep(ctx, dw)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type feed struct {
+	category DouCategory
+	exp      string
+}
+
+// sweep scrapes every category × experience feed once and returns when all of them are done
+func sweep(ctx context.Context, dw *DouWorker) {
+	start := time.Now()
+	feeds := make(chan feed)
+	failed := int32(0)
+	wg := sync.WaitGroup{}
+	for i := 0; i < dw.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range feeds {
+				err := scrapFeed(ctx, dw, f.category, f.exp)
+				if err != nil && !errors.Is(err, errCircuitOpen) {
 					fmt.Println(err)
-				} else {
-					time.Sleep(1 * time.Second)
+				}
+				if err != nil {
+					atomic.AddInt32(&failed, 1)
 				}
 			}
+		}()
+	}
+
+	count := 0
+	for _, category := range dw.Categories() {
+		for _, exp := range dw.experienceFilters {
+			select {
+			case feeds <- feed{category, exp}:
+				count++
+			case <-ctx.Done():
+			}
 		}
-		<-ticker.C
 	}
+	close(feeds)
+	wg.Wait()
+
+	duration := time.Since(start)
+	sweepDuration.Observe(duration.Seconds())
+	fmt.Printf("Sweep of %d feeds took %v, %d failed\n", count, duration.Round(time.Millisecond), failed)
+}
+
+// scrapFeed scraps the feed since its checkpoint, waiting for its turn under the host rate limit
+func scrapFeed(ctx context.Context, dw *DouWorker, category DouCategory, exp string) error {
+	lastTimeChecked, err := dw.storage.GetLastTimeCheckedUTC(ctx, category, exp)
+	if err != nil {
+		return err
+	}
+	if lastTimeChecked.IsZero() {
+		lastTimeChecked = time.Now().UTC().Add(-dw.lookBack)
+	}
+
+	// feeds are skipped right away instead of waiting for their turn just to be refused
+	if dw.breaker.IsOpen() {
+		return errCircuitOpen
+	}
+	if err := dw.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = scrapCategory(ctx, dw, category, exp, lastTimeChecked)
+	observeScrape(category, time.Since(start), err)
+	return err
 }
 
-func scrapCategory(dw *DouWorker, category DouCategory, exp string, lastTimeChecked time.Time) error {
-	c := createCollector()
+// scrapCategory hands off vacancies published since lastTimeChecked once the whole feed is parsed,
+// then moves the checkpoint to the newest of them, so failed requests don't skip vacancies.
+// Feeds which didn't change since the last visit aren't parsed
+func scrapCategory(ctx context.Context, dw *DouWorker, category DouCategory, exp string, lastTimeChecked time.Time) error {
+	validators, err := dw.storage.GetFeedValidators(ctx, category, exp)
+	if err != nil {
+		return err
+	}
+
+	vacancies := []DouVacancy{}
+	checkpoint := lastTimeChecked
+	newValidators := validators
+	isUnchanged := false
+	c := dw.newCollector()
+	c.OnResponse(func(r *colly.Response) {
+		newValidators = FeedValidators{
+			ETag:         r.Headers.Get("ETag"),
+			LastModified: r.Headers.Get("Last-Modified"),
+			ContentHash:  contentHash(r.Body),
+		}
+		isUnchanged = newValidators.ContentHash == validators.ContentHash
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		isUnchanged = r.StatusCode == http.StatusNotModified
+	})
 	c.OnXML("//item", func(e *colly.XMLElement) {
+		if isUnchanged {
+			return
+		}
+
 		pubDate, err := time.Parse(time.RFC1123Z, e.ChildText("//pubDate"))
 		if err != nil {
 			fmt.Println(err)
-		} else if res := pubDate.UTC().Sub(lastTimeChecked); res.Minutes() > 0 {
+		} else if !pubDate.UTC().Before(lastTimeChecked) {
 			vac := DouVacancy{
 				url:          strings.ReplaceAll(e.ChildText("//link"), "?utm_source=jobsrss", ""),
 				name:         e.ChildText("//title"),
+				description:  htmlToText(e.ChildText("//description")),
 				categoryId:   category.id,
 				categoryName: category.name,
 				experience:   exp,
 			}
+			parseVacancyTitle(&vac)
+			vac.snippet = truncate(vac.description, snippetLength)
 			fmt.Printf("Detected new vacancy: %+v\n", vac)
-			dw.newVacancyChan <- vac
+			vacancies = append(vacancies, vac)
+			if pubDate.UTC().After(checkpoint) {
+				checkpoint = pubDate.UTC()
+			}
 		}
 
 	})
 	c.OnRequest(func(r *colly.Request) {
-		dw.storage.SetLastTimeCheckedUTC(category, exp)
+		if validators.ETag != "" {
+			r.Headers.Set("If-None-Match", validators.ETag)
+		}
+		if validators.LastModified != "" {
+			r.Headers.Set("If-Modified-Since", validators.LastModified)
+		}
 		fmt.Printf("Visiting Category: %s EXP:%s\n", category.name, exp)
 	})
 
-	err := c.Visit(category.url + "&exp=" + exp)
+	err = dw.visit(c, category.url+"&exp="+exp)
+	if isUnchanged {
+		fmt.Printf("Category %s EXP:%s didn't change since the last visit\n", category.name, exp)
+		if newValidators != validators {
+			return dw.storage.SetFeedValidators(ctx, category, exp, newValidators)
+		}
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// the checkpoint only moves once the bot acknowledged every vacancy, a failed handoff gets them scraped again
+	handled := make(chan error, len(vacancies))
+	for _, vac := range vacancies {
+		vacanciesDetectedTotal.WithLabelValues(category.name).Inc()
+		dw.newVacancyChan <- vacancyHandoff{vacancy: vac, handled: handled}
+	}
+	for range vacancies {
+		if handoffErr := <-handled; handoffErr != nil {
+			err = handoffErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if checkpoint.After(lastTimeChecked) {
+		if err := dw.storage.SetLastTimeCheckedUTC(ctx, category, exp, checkpoint); err != nil {
+			return err
+		}
+	}
+	// validators are stored after the checkpoint, so a feed is never skipped before its vacancies are handed off
+	return dw.storage.SetFeedValidators(ctx, category, exp, newValidators)
+}
+
+func contentHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
 }
 
-func scrapCategories() ([]DouCategory, error) {
+func scrapCategories(ctx context.Context, dw *DouWorker) ([]DouCategory, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	result := []DouCategory{}
-	c := createCollector()
+	c := dw.newCollector()
 	c.OnHTML("select[name='category'] option", func(e *colly.HTMLElement) {
-		result = append(result, DouCategory{
-			id:   e.Attr("value"),
-			name: e.Text,
-			url:  feedUrl + url.QueryEscape(e.Attr("value")),
-		})
+		result = append(result, dw.newCategory(e.Attr("value"), e.Text))
 	})
 	c.OnRequest(func(r *colly.Request) {
 		fmt.Println("Visiting", r.URL)
@@ -129,7 +454,7 @@ func scrapCategories() ([]DouCategory, error) {
 			fmt.Printf("%+v\n", cat.name)
 		}
 	})
-	err := c.Visit(categoriesUrl)
+	err := dw.visit(c, dw.baseUrl+categoriesPath)
 	if err != nil {
 		return nil, err
 	}
@@ -137,8 +462,150 @@ func scrapCategories() ([]DouCategory, error) {
 	return result, nil
 }
 
-func createCollector() *colly.Collector {
+// visit requests DOU through the circuit breaker, so it isn't hammered while it throttles or fails
+func (dw *DouWorker) visit(c *colly.Collector, url string) error {
+	generation, err := dw.breaker.Allow()
+	if err != nil {
+		return err
+	}
+
+	status := 0
+	retryAfter := time.Duration(0)
+	c.OnResponse(func(r *colly.Response) {
+		status = r.StatusCode
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		status = r.StatusCode
+		retryAfter = parseRetryAfter(r.Headers)
+	})
+
+	err = c.Visit(url)
+	dw.breaker.Report(generation, status, retryAfter)
+	return err
+}
+
+// parseVacancyTitle fills vacancy details from RSS title formatted like
+// `Senior Golang Developer в Company, $4000–5500, Київ, Львів, віддалено`
+func parseVacancyTitle(vac *DouVacancy) {
+	vac.position = vac.name
+	idx := strings.LastIndex(vac.name, " в ")
+	if idx == -1 {
+		return
+	}
+
+	vac.position = strings.TrimSpace(vac.name[:idx])
+	details := strings.Split(vac.name[idx+len(" в "):], ",")
+	vac.company = strings.TrimSpace(details[0])
+	for _, detail := range details[1:] {
+		detail = strings.TrimSpace(detail)
+		lower := strings.ToLower(detail)
+		switch {
+		case detail == "":
+		case strings.Contains(lower, "віддалено") || strings.Contains(lower, "remote"):
+			vac.remote = true
+		case strings.Contains(lower, "релокейт") || strings.Contains(lower, "relocat"):
+			vac.relocation = true
+		default:
+			if salary, ok := parseSalary(detail); ok {
+				vac.salary = salary
+			} else {
+				vac.cities = append(vac.cities, detail)
+			}
+		}
+	}
+}
+
+// parseSalary parses salaries like `$4000–5500`, `від $3000`, `до 50 000 грн`
+func parseSalary(text string) (DouSalary, bool) {
+	m := salaryRegexp.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil {
+		return DouSalary{}, false
+	}
+
+	currency := currencySymbols[m[2]]
+	if currency == "" {
+		currency = currencySymbols[strings.ToLower(m[5])]
+	}
+	if currency == "" {
+		return DouSalary{}, false
+	}
+
+	from, err := strconv.Atoi(strings.Join(strings.Fields(m[3]), ""))
+	if err != nil {
+		return DouSalary{}, false
+	}
+	to := from
+	if m[4] != "" {
+		if to, err = strconv.Atoi(strings.Join(strings.Fields(m[4]), "")); err != nil {
+			return DouSalary{}, false
+		}
+	}
+
+	switch strings.ToLower(m[1]) {
+	case "від":
+		return DouSalary{min: from, currency: currency}, true
+	case "до":
+		return DouSalary{max: from, currency: currency}, true
+	}
+	return DouSalary{min: from, max: to, currency: currency}, true
+}
+
+func (s DouSalary) IsStated() bool {
+	return s.min != 0 || s.max != 0
+}
+
+func (s DouSalary) String() string {
+	symbol := s.currency
+	for k, v := range currencySymbols {
+		if v == s.currency && k != "грн" {
+			symbol = k
+		}
+	}
+
+	switch {
+	case !s.IsStated():
+		return ""
+	case s.max == 0:
+		return fmt.Sprintf("від %s%d", symbol, s.min)
+	case s.min == 0:
+		return fmt.Sprintf("до %s%d", symbol, s.max)
+	case s.min == s.max:
+		return fmt.Sprintf("%s%d", symbol, s.min)
+	}
+	return fmt.Sprintf("%s%d–%d", symbol, s.min, s.max)
+}
+
+func htmlToText(text string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(text))
+	if err != nil {
+		return strings.Join(strings.Fields(text), " ")
+	}
+	return strings.Join(strings.Fields(doc.Text()), " ")
+}
+
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:length])) + "…"
+}
+
+// createDouTransport creates transport shared by all collectors of a worker, so connections to DOU are reused between feeds,
+// it keeps an idle connection for every worker since each of them requests one feed at a time
+func createDouTransport(workers int) *http.Transport {
+	return &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: workers,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+}
+
+func createCollector(transport http.RoundTripper) *colly.Collector {
 	c := colly.NewCollector()
+	c.WithTransport(transport)
 	c.UserAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/51.0.2704.103 Safari/537.36"
 	return c
 }
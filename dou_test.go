@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeItem struct {
+	title       string
+	link        string
+	description string
+	pubDate     time.Time
+}
+
+// fakeDou serves categories page and RSS feeds the same way jobs.dou.ua does
+type fakeDou struct {
+	*httptest.Server
+	categories map[string]string
+	feeds      map[string][]fakeItem
+}
+
+func newFakeDou(t *testing.T) *fakeDou {
+	fd := &fakeDou{
+		categories: map[string]string{},
+		feeds:      map[string][]fakeItem{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/vacancies/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<html><body><form><select name="category">`)
+		for id, name := range fd.categories {
+			fmt.Fprintf(w, `<option value="%s">%s</option>`, id, name)
+		}
+		fmt.Fprint(w, `</select></form></body></html>`)
+	})
+	mux.HandleFunc("/vacancies/feeds/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		key := r.URL.Query().Get("category") + "/" + r.URL.Query().Get("exp")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><rss version="2.0"><channel><title>DOU</title>`)
+		for _, item := range fd.feeds[key] {
+			fmt.Fprintf(w, `<item><title>%s</title><link>%s?utm_source=jobsrss</link><description>%s</description><pubDate>%s</pubDate></item>`,
+				html.EscapeString(item.title), item.link, html.EscapeString(item.description), item.pubDate.Format(time.RFC1123Z))
+		}
+		fmt.Fprint(w, `</channel></rss>`)
+	})
+
+	fd.Server = httptest.NewServer(mux)
+	t.Cleanup(fd.Close)
+	return fd
+}
+
+func collectVacancies(dw *DouWorker, scrap func() error) ([]DouVacancy, error) {
+	return handleVacancies(dw, nil, scrap)
+}
+
+// handleVacancies acknowledges every vacancy handed off by scrap with handoffErr
+func handleVacancies(dw *DouWorker, handoffErr error, scrap func() error) ([]DouVacancy, error) {
+	res := []DouVacancy{}
+	done := make(chan error)
+	go func() {
+		done <- scrap()
+	}()
+
+	for {
+		select {
+		case handoff := <-dw.newVacancyChan:
+			res = append(res, handoff.vacancy)
+			handoff.handled <- handoffErr
+		case err := <-done:
+			return res, err
+		}
+	}
+}
+
+func TestScrapCategories(t *testing.T) {
+	fd := newFakeDou(t)
+	fd.categories["Golang"] = "Golang"
+	fd.categories["C++"] = "C++"
+
+	dw := CreateDouWorker(CreateMemoryStorage(), fd.URL, nil, 0, 0)
+	categories, err := scrapCategories(context.Background(), dw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(categories) != 2 {
+		t.Fatalf("expected 2 categories, got %d", len(categories))
+	}
+	for _, c := range categories {
+		if c.name != fd.categories[c.id] {
+			t.Errorf("category %s has name %s", c.id, c.name)
+		}
+		if !strings.HasPrefix(c.url, fd.URL+feedPath) {
+			t.Errorf("category %s has feed url %s", c.id, c.url)
+		}
+	}
+}
+
+func TestRefreshCategoriesRenamesSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	fd := newFakeDou(t)
+	fd.categories["Golang"] = "Go"
+
+	storage := CreateMemoryStorage()
+	// categories weren't stored yet, like on the first run after upgrade
+	storage.SubscribeUser(ctx, CreateSubscriptionCategory(DouCategory{id: "Golang", name: "Golang"}, "1-3"), 1, 1, "user")
+
+	dw := CreateDouWorker(storage, fd.URL, nil, 0, 0)
+	if err := refreshCategories(ctx, dw); err != nil {
+		t.Fatal(err)
+	}
+
+	if categories := dw.Categories(); len(categories) != 1 || categories[0].name != "Go" {
+		t.Errorf("unexpected categories %+v", categories)
+	}
+	if records, _ := storage.GetCategories(ctx); len(records) != 1 || records[0].Name != "Go" {
+		t.Errorf("categories weren't stored: %+v", records)
+	}
+	if subInfo, _ := storage.GetSubscriptionInfo(ctx, 1); len(subInfo.Subscriptions) != 1 || subInfo.Subscriptions[0].NameCategory != "Go" {
+		t.Errorf("subscription wasn't renamed: %+v", subInfo.Subscriptions)
+	}
+}
+
+// renameCountingStorage counts categories renamed in subscriptions
+type renameCountingStorage struct {
+	*MemoryStorage
+	renamed []string
+}
+
+func (rs *renameCountingStorage) RenameCategory(ctx context.Context, categoryId string, name string) error {
+	rs.renamed = append(rs.renamed, categoryId)
+	return rs.MemoryStorage.RenameCategory(ctx, categoryId, name)
+}
+
+func TestRefreshCategoriesRenamesOnlyChangedCategories(t *testing.T) {
+	ctx := context.Background()
+	fd := newFakeDou(t)
+	fd.categories["Golang"] = "Go"
+	fd.categories["Java"] = "Java"
+
+	storage := &renameCountingStorage{MemoryStorage: CreateMemoryStorage()}
+	if err := storage.SaveCategories(ctx, []CategoryRecord{{ID: "Golang", Name: "Golang"}, {ID: "Java", Name: "Java"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	dw := CreateDouWorker(storage, fd.URL, nil, 0, 0)
+	if err := refreshCategories(ctx, dw); err != nil {
+		t.Fatal(err)
+	}
+	if len(storage.renamed) != 1 || storage.renamed[0] != "Golang" {
+		t.Errorf("expected only renamed category to be updated, got %v", storage.renamed)
+	}
+
+	storage.renamed = nil
+	if err := refreshCategories(ctx, dw); err != nil {
+		t.Fatal(err)
+	}
+	if len(storage.renamed) != 0 {
+		t.Errorf("unchanged categories were updated: %v", storage.renamed)
+	}
+}
+
+func TestRunFallsBackToStoredCategories(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(failing.Close)
+
+	storage := CreateMemoryStorage()
+	dw := CreateDouWorker(storage, failing.URL, nil, 0, 0)
+	if err := dw.Run(ctx); err == nil {
+		t.Fatal("expected error without stored categories")
+	}
+
+	storage.SaveCategories(ctx, []CategoryRecord{{ID: "Golang", Name: "Golang"}})
+	dw = CreateDouWorker(storage, failing.URL, nil, 0, 0)
+	if err := dw.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if categories := dw.Categories(); len(categories) != 1 || categories[0].url != failing.URL+feedPath+"Golang" {
+		t.Errorf("unexpected categories %+v", categories)
+	}
+}
+
+func TestScrapCategoryDetectsNewVacancies(t *testing.T) {
+	fd := newFakeDou(t)
+	fd.categories["Golang"] = "Golang"
+
+	lastTimeChecked := time.Date(2023, time.March, 17, 18, 0, 0, 0, time.UTC)
+	fd.feeds["Golang/1-3"] = []fakeItem{
+		{title: "Newest", link: "https://jobs.dou.ua/companies/a/vacancies/3/", description: "<p>Go &amp; <b>Kubernetes</b></p>", pubDate: lastTimeChecked.Add(time.Hour)},
+		{title: "New", link: "https://jobs.dou.ua/companies/a/vacancies/2/", pubDate: lastTimeChecked.Add(time.Minute)},
+		{title: "Checkpoint", link: "https://jobs.dou.ua/companies/a/vacancies/4/", pubDate: lastTimeChecked},
+		{title: "Old", link: "https://jobs.dou.ua/companies/a/vacancies/1/", pubDate: lastTimeChecked.Add(-time.Minute)},
+	}
+
+	storage := CreateMemoryStorage()
+	dw := CreateDouWorker(storage, fd.URL, nil, 0, 0)
+	categories, err := scrapCategories(context.Background(), dw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vacancies, err := collectVacancies(dw, func() error {
+		return scrapCategory(context.Background(), dw, categories[0], "1-3", lastTimeChecked)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// vacancy published in the same second as the checkpoint could be missed by the last visit
+	if len(vacancies) != 3 {
+		t.Fatalf("expected 3 new vacancies, got %d: %+v", len(vacancies), vacancies)
+	}
+	for i, name := range []string{"Newest", "New", "Checkpoint"} {
+		vac := vacancies[i]
+		if vac.name != name || vac.categoryId != "Golang" || vac.experience != "1-3" {
+			t.Errorf("unexpected vacancy %+v", vac)
+		}
+		if strings.Contains(vac.url, "utm_source") {
+			t.Errorf("vacancy url %s wasn't cleaned", vac.url)
+		}
+	}
+
+	if vacancies[0].snippet != "Go & Kubernetes" {
+		t.Errorf("unexpected snippet %q", vacancies[0].snippet)
+	}
+
+	if checked, _ := storage.GetLastTimeCheckedUTC(context.Background(), categories[0], "1-3"); !checked.Equal(lastTimeChecked.Add(time.Hour)) {
+		t.Errorf("last time checked wasn't moved to the newest vacancy: %v", checked)
+	}
+}
+
+func TestScrapCategoryWithoutNewVacancies(t *testing.T) {
+	fd := newFakeDou(t)
+	fd.categories["Golang"] = "Golang"
+
+	lastTimeChecked := time.Now().UTC()
+	fd.feeds["Golang/"] = []fakeItem{
+		{title: "Old", link: "https://jobs.dou.ua/companies/a/vacancies/1/", pubDate: lastTimeChecked.Add(-time.Hour)},
+	}
+
+	dw := CreateDouWorker(CreateMemoryStorage(), fd.URL, nil, 0, 0)
+	category := DouCategory{id: "Golang", name: "Golang", url: fd.URL + feedPath + "Golang"}
+	vacancies, err := collectVacancies(dw, func() error {
+		return scrapCategory(context.Background(), dw, category, "", lastTimeChecked)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vacancies) != 0 {
+		t.Fatalf("expected no vacancies, got %+v", vacancies)
+	}
+}
+
+func TestScrapCategoryKeepsCheckpointWhenFeedFails(t *testing.T) {
+	fd := newFakeDou(t)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(failing.Close)
+
+	storage := CreateMemoryStorage()
+	dw := CreateDouWorker(storage, fd.URL, nil, 0, 0)
+	category := DouCategory{id: "Golang", name: "Golang", url: failing.URL + feedPath + "Golang"}
+	_, err := collectVacancies(dw, func() error {
+		return scrapCategory(context.Background(), dw, category, "", time.Now().UTC().Add(-time.Hour))
+	})
+	if err == nil {
+		t.Fatal("expected feed error")
+	}
+
+	if checked, _ := storage.GetLastTimeCheckedUTC(context.Background(), category, ""); !checked.IsZero() {
+		t.Errorf("checkpoint was moved by failed request: %v", checked)
+	}
+}
+
+func TestScrapCategoryKeepsCheckpointWhenHandoffFails(t *testing.T) {
+	fd := newFakeDou(t)
+	lastTimeChecked := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	fd.feeds["Golang/"] = []fakeItem{
+		{title: "New", link: "https://jobs.dou.ua/companies/a/vacancies/1/", pubDate: lastTimeChecked.Add(time.Minute)},
+	}
+
+	storage := CreateMemoryStorage()
+	dw := CreateDouWorker(storage, fd.URL, nil, 0, 0)
+	category := DouCategory{id: "Golang", name: "Golang", url: fd.URL + feedPath + "Golang"}
+	vacancies, err := handleVacancies(dw, errors.New("storage is unavailable"), func() error {
+		return scrapCategory(context.Background(), dw, category, "", lastTimeChecked)
+	})
+	if err == nil || len(vacancies) != 1 {
+		t.Fatalf("expected handoff error for 1 vacancy, got %v: %+v", err, vacancies)
+	}
+
+	if checked, _ := storage.GetLastTimeCheckedUTC(context.Background(), category, ""); !checked.IsZero() {
+		t.Errorf("checkpoint was moved past vacancy which wasn't handed off: %v", checked)
+	}
+	if validators, _ := storage.GetFeedValidators(context.Background(), category, ""); validators.ContentHash != "" {
+		t.Errorf("validators were stored for feed which wasn't handed off: %+v", validators)
+	}
+}
+
+func TestScrapCategorySkipsUnchangedFeeds(t *testing.T) {
+	pubDate := time.Now().UTC().Add(-time.Minute)
+	body := `<?xml version="1.0" encoding="utf-8"?><rss version="2.0"><channel><title>DOU</title>` +
+		`<item><title>New</title><link>https://jobs.dou.ua/companies/a/vacancies/1/</link><pubDate>` + pubDate.Format(time.RFC1123Z) + `</pubDate></item>` +
+		`</channel></rss>`
+
+	for _, withETag := range []bool{true, false} {
+		notModified := 0
+		feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if withETag {
+				if r.Header.Get("If-None-Match") == `"v1"` {
+					notModified++
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Header().Set("ETag", `"v1"`)
+			}
+			w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+			fmt.Fprint(w, body)
+		}))
+		t.Cleanup(feed.Close)
+
+		dw := CreateDouWorker(CreateMemoryStorage(), feed.URL, nil, 0, 0)
+		category := DouCategory{id: "Golang", name: "Golang", url: feed.URL + feedPath + "Golang"}
+		for i, expected := range []int{1, 0} {
+			// the same checkpoint is passed twice, so only skipping the feed prevents a duplicate
+			vacancies, err := collectVacancies(dw, func() error {
+				return scrapCategory(context.Background(), dw, category, "", pubDate.Add(-time.Hour))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(vacancies) != expected {
+				t.Errorf("etag %v, visit %d: expected %d vacancies, got %d", withETag, i, expected, len(vacancies))
+			}
+		}
+
+		if withETag && notModified != 1 {
+			t.Errorf("expected conditional request to be answered with 304 once, got %d", notModified)
+		}
+	}
+}
+
+func TestParseVacancyTitle(t *testing.T) {
+	tests := []struct {
+		title    string
+		expected DouVacancy
+	}{
+		{
+			title: "Senior Golang Developer в Company, $4000–5500, Київ, Львів, віддалено",
+			expected: DouVacancy{position: "Senior Golang Developer", company: "Company", salary: DouSalary{min: 4000, max: 5500, currency: "USD"},
+				cities: []string{"Київ", "Львів"}, remote: true},
+		},
+		{
+			title:    "Go Engineer в Big Co, від $3000, релокейт",
+			expected: DouVacancy{position: "Go Engineer", company: "Big Co", salary: DouSalary{min: 3000, currency: "USD"}, relocation: true},
+		},
+		{
+			title:    "QA в Studio, до 50 000 грн, Одеса",
+			expected: DouVacancy{position: "QA", company: "Studio", salary: DouSalary{max: 50000, currency: "UAH"}, cities: []string{"Одеса"}},
+		},
+		{
+			title:    "Unknown format",
+			expected: DouVacancy{position: "Unknown format"},
+		},
+	}
+
+	for _, test := range tests {
+		vac := DouVacancy{name: test.title}
+		parseVacancyTitle(&vac)
+		test.expected.name = test.title
+		if fmt.Sprintf("%+v", vac) != fmt.Sprintf("%+v", test.expected) {
+			t.Errorf("%s parsed as %+v", test.title, vac)
+		}
+	}
+}
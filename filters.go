@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// salaryRatesToUSD are rough rates used to compare salaries with subscription minimum set in USD
+var salaryRatesToUSD = map[string]float64{"USD": 1, "EUR": 1.1, "UAH": 0.027}
+
+const (
+	remoteLocation     = "Віддалено"
+	relocationLocation = "Релокейт"
+)
+
+// Matches checks vacancy against subscription salary, location and keyword filters
+func (sc SubscriptionCategory) Matches(vacancy DouVacancy) bool {
+	return sc.matchesSalary(vacancy.salary) && sc.matchesLocation(vacancy) && sc.matchesKeywords(vacancy)
+}
+
+// matchesLocation checks that vacancy is in any of subscription cities, remote or with relocation if those are chosen
+func (sc SubscriptionCategory) matchesLocation(vacancy DouVacancy) bool {
+	if len(sc.Cities) == 0 && !sc.Remote && !sc.Relocation {
+		return true
+	}
+	if (sc.Remote && vacancy.remote) || (sc.Relocation && vacancy.relocation) {
+		return true
+	}
+
+	for _, city := range sc.Cities {
+		for _, vacancyCity := range vacancy.cities {
+			if strings.EqualFold(city, vacancyCity) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesSalary compares the lower bound of vacancy salary with subscription minimum,
+// the upper bound is used only for salaries like `до X`
+func (sc SubscriptionCategory) matchesSalary(salary DouSalary) bool {
+	if !salary.IsStated() {
+		return !sc.HideNoSalary
+	}
+	if sc.MinSalary == 0 {
+		return true
+	}
+
+	rate, ok := salaryRatesToUSD[salary.currency]
+	if !ok {
+		return true
+	}
+
+	lower := salary.min
+	if lower == 0 {
+		lower = salary.max
+	}
+	return float64(lower)*rate >= float64(sc.MinSalary)
+}
+
+// matchesKeywords checks that vacancy contains any of include keywords and none of exclude ones
+func (sc SubscriptionCategory) matchesKeywords(vacancy DouVacancy) bool {
+	text := strings.ToLower(vacancy.name + "\n" + vacancy.description)
+	for _, keyword := range sc.ExcludeKeywords {
+		if strings.Contains(text, strings.ToLower(keyword)) {
+			return false
+		}
+	}
+
+	if len(sc.IncludeKeywords) == 0 {
+		return true
+	}
+
+	for _, keyword := range sc.IncludeKeywords {
+		if strings.Contains(text, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsVacancy tells if any of chat's subscriptions to the vacancy category and experience lets it through filters,
+// vacancy is accepted when there are none, since the chat was found as a subscriber of the category
+func acceptsVacancy(subInfo SubscriptionInfo, vacancy DouVacancy) bool {
+	isFound := false
+	for _, sub := range subInfo.Subscriptions {
+		if sub.IDCategory != IdToDBId(vacancy.categoryId) || sub.Experience != IdToDBId(vacancy.experience) {
+			continue
+		}
+		if sub.Matches(vacancy) {
+			return true
+		}
+		isFound = true
+	}
+	return !isFound
+}
+
+// sameAs tells if both are the same subscription, category, experience and filters make its identity
+func (sc SubscriptionCategory) sameAs(other SubscriptionCategory) bool {
+	return sc.IDCategory == other.IDCategory && sc.Experience == other.Experience &&
+		sc.MinSalary == other.MinSalary && sc.HideNoSalary == other.HideNoSalary &&
+		sc.Remote == other.Remote && sc.Relocation == other.Relocation &&
+		sameWords(sc.IncludeKeywords, other.IncludeKeywords) && sameWords(sc.ExcludeKeywords, other.ExcludeKeywords) &&
+		sameWords(sc.Cities, other.Cities)
+}
+
+// sameWords compares lists ignoring order and case, the same way filters are matched
+func sameWords(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	counts := map[string]int{}
+	for _, word := range a {
+		counts[strings.ToLower(word)]++
+	}
+	for _, word := range b {
+		word = strings.ToLower(word)
+		if counts[word] == 0 {
+			return false
+		}
+		counts[word]--
+	}
+	return true
+}
+
+// parseKeywords splits comma separated keywords, ones starting with `-` are excluded
+func parseKeywords(text string) (include []string, exclude []string) {
+	for _, keyword := range strings.Split(text, ",") {
+		keyword = strings.TrimSpace(keyword)
+		if strings.HasPrefix(keyword, "-") {
+			if keyword = strings.TrimSpace(strings.TrimPrefix(keyword, "-")); keyword != "" {
+				exclude = append(exclude, keyword)
+			}
+			continue
+		}
+		if keyword != "" {
+			include = append(include, keyword)
+		}
+	}
+	return include, exclude
+}
+
+func formatKeywords(sub SubscriptionCategory) string {
+	keywords := append([]string(nil), sub.IncludeKeywords...)
+	for _, keyword := range sub.ExcludeKeywords {
+		keywords = append(keywords, "-"+keyword)
+	}
+	return strings.Join(keywords, ", ")
+}
+
+func formatSalaryFilter(sub SubscriptionCategory) string {
+	res := "будь-яка"
+	if sub.MinSalary > 0 {
+		res = fmt.Sprintf("від $%d", sub.MinSalary)
+	}
+	if sub.HideNoSalary {
+		res += ", без вакансій з не вказаною зарплатою"
+	}
+	return res
+}
+
+// parseLocations splits comma separated cities, remote and relocation options
+func parseLocations(text string) (cities []string, remote bool, relocation bool) {
+	for _, location := range strings.Split(text, ",") {
+		location = strings.TrimSpace(location)
+		switch {
+		case location == "" || location == locationOptions[0]:
+		case strings.EqualFold(location, remoteLocation):
+			remote = true
+		case strings.EqualFold(location, relocationLocation):
+			relocation = true
+		default:
+			cities = append(cities, location)
+		}
+	}
+	return cities, remote, relocation
+}
+
+func formatLocationFilter(sub SubscriptionCategory) string {
+	locations := append([]string(nil), sub.Cities...)
+	if sub.Remote {
+		locations = append(locations, remoteLocation)
+	}
+	if sub.Relocation {
+		locations = append(locations, relocationLocation)
+	}
+	if len(locations) == 0 {
+		return locationOptions[0]
+	}
+	return strings.Join(locations, ", ")
+}
@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+func TestSubscriptionMatches(t *testing.T) {
+	vacancy := DouVacancy{
+		name:        "Senior Golang Developer в Company, $4000–5500, віддалено (remote)",
+		description: "Go, Kubernetes",
+		salary:      DouSalary{min: 4000, max: 5500, currency: "USD"},
+		remote:      true,
+	}
+	noSalary := DouVacancy{name: "Middle Golang Developer в Company, Київ", cities: []string{"Київ"}}
+	uahSalary := DouVacancy{name: "Middle Golang Developer в Company", salary: DouSalary{max: 60000, currency: "UAH"}}
+	wideSalary := DouVacancy{name: "Golang Developer в Company", salary: DouSalary{min: 1000, max: 4000, currency: "USD"}}
+
+	tests := []struct {
+		name     string
+		sub      SubscriptionCategory
+		vacancy  DouVacancy
+		expected bool
+	}{
+		{"no filters", SubscriptionCategory{}, vacancy, true},
+		{"include matches case insensitive", SubscriptionCategory{IncludeKeywords: []string{"REMOTE"}}, vacancy, true},
+		{"include in description", SubscriptionCategory{IncludeKeywords: []string{"python", "kubernetes"}}, vacancy, true},
+		{"include doesn't match", SubscriptionCategory{IncludeKeywords: []string{"python"}}, vacancy, false},
+		{"exclude matches", SubscriptionCategory{IncludeKeywords: []string{"remote"}, ExcludeKeywords: []string{"senior"}}, vacancy, false},
+		{"salary above minimum", SubscriptionCategory{MinSalary: 4000}, vacancy, true},
+		{"salary below minimum", SubscriptionCategory{MinSalary: 5000}, vacancy, false},
+		{"range only reaches minimum", SubscriptionCategory{MinSalary: 4000}, wideSalary, false},
+		{"range starts above minimum", SubscriptionCategory{MinSalary: 1000}, wideSalary, true},
+		{"salary converted from UAH", SubscriptionCategory{MinSalary: 2000}, uahSalary, false},
+		{"no salary shown", SubscriptionCategory{MinSalary: 6000}, noSalary, true},
+		{"no salary hidden", SubscriptionCategory{HideNoSalary: true}, noSalary, false},
+		{"city matches", SubscriptionCategory{Cities: []string{"Львів", "київ"}}, noSalary, true},
+		{"city doesn't match", SubscriptionCategory{Cities: []string{"Львів"}}, noSalary, false},
+		{"remote matches", SubscriptionCategory{Cities: []string{"Львів"}, Remote: true}, vacancy, true},
+		{"relocation doesn't match", SubscriptionCategory{Relocation: true}, vacancy, false},
+	}
+
+	for _, test := range tests {
+		if res := test.sub.Matches(test.vacancy); res != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, res)
+		}
+	}
+}
+
+func TestSubscriptionsWithDifferentFilters(t *testing.T) {
+	java := DouCategory{id: "Java", name: "Java"}
+	remote := CreateSubscriptionCategory(java, "3-5")
+	remote.Remote = true
+	kyiv := CreateSubscriptionCategory(java, "3-5")
+	kyiv.Cities = []string{"Київ"}
+
+	subs, added := appendNewSubscriptions([]SubscriptionCategory{remote}, []SubscriptionCategory{kyiv})
+	if added != 1 || len(subs) != 2 {
+		t.Fatalf("subscription with other filters wasn't added: %+v", subs)
+	}
+
+	same := CreateSubscriptionCategory(java, "3-5")
+	same.Cities = []string{"київ"}
+	if _, added := appendNewSubscriptions(subs, []SubscriptionCategory{same}); added != 0 {
+		t.Error("the same subscription was added twice")
+	}
+
+	vacancy := DouVacancy{categoryId: "Java", experience: "3-5", cities: []string{"Київ"}}
+	if !acceptsVacancy(SubscriptionInfo{Subscriptions: subs}, vacancy) {
+		t.Error("vacancy matching the second subscription was rejected")
+	}
+	if acceptsVacancy(SubscriptionInfo{Subscriptions: subs[:1]}, vacancy) {
+		t.Error("vacancy matching no subscription was accepted")
+	}
+}
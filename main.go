@@ -1,30 +1,82 @@
 package main
 
 import (
+	"context"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 )
 
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	//keeping alive on replit
-	http.HandleFunc("/", handle)
-	go http.ListenAndServe(":0", nil)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	storage, err := CreateMongoStorage()
+	backend, err := CreateStorage(ctx, os.Getenv("STORAGE"))
 	if err != nil {
 		panic(err)
 	}
+	storage := CreateRetryStorage(backend)
+
+	worker := CreateDouWorker(storage, os.Getenv("DOU_URL"), nil, lookBack(), scrapWorkers())
+	server := createOpsServer(os.Getenv("PORT"), storage, worker)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Println(err)
+		}
+	}()
 
-	worker := CreateDouWorker(storage)
-	if err := worker.Run(); err != nil {
+	if err := worker.Run(ctx); err != nil {
 		panic(err)
 	}
 
 	bot := CreateTelegramBot(storage, worker)
-	bot.Run()
+	bot.Run(ctx)
+
+	// root context is cancelled already, so cleanup gets its own deadline
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println(err)
+	}
+	if err := storage.Close(shutdownCtx); err != nil {
+		log.Println(err)
+	}
+	log.Println("Stopped")
+}
+
+// scrapWorkers reads DOU_WORKERS, zero means the default amount
+func scrapWorkers() int {
+	value := os.Getenv("DOU_WORKERS")
+	if value == "" {
+		return 0
+	}
+
+	res, err := strconv.Atoi(value)
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	return res
 }
 
-func handle(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Content-Type", "application/text")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Success"))
+// lookBack reads DOU_LOOK_BACK like `48h`, zero means the default window
+func lookBack() time.Duration {
+	value := os.Getenv("DOU_LOOK_BACK")
+	if value == "" {
+		return 0
+	}
+
+	res, err := time.ParseDuration(value)
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	return res
 }
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type MemoryStorage struct {
+	lock          sync.RWMutex
+	categories    map[string]CategoryInfo
+	subscriptions map[int64]SubscriptionInfo
+	sentVacancies map[string]time.Time
+	pending       map[int64][]PendingVacancy
+	outbox        map[string]OutboxMessage
+	categoryList  []CategoryRecord
+	channelLinks  map[int64]ChannelLink
+}
+
+func CreateMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		categories:    map[string]CategoryInfo{},
+		subscriptions: map[int64]SubscriptionInfo{},
+		sentVacancies: map[string]time.Time{},
+		pending:       map[int64][]PendingVacancy{},
+		outbox:        map[string]OutboxMessage{},
+		channelLinks:  map[int64]ChannelLink{},
+	}
+}
+
+func (ms *MemoryStorage) Close(ctx context.Context) error {
+	return nil
+}
+
+func (ms *MemoryStorage) GetAllSubscribers(ctx context.Context, categoryName string, categoryId string, exp string) ([]SubscriptionInfo, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	res := []SubscriptionInfo{}
+	for _, subInfo := range ms.subscriptions {
+		if subInfo.Inactive {
+			continue
+		}
+		for _, sub := range subInfo.Subscriptions {
+			if sub.IDCategory == IdToDBId(categoryId) && sub.Experience == IdToDBId(exp) {
+				res = append(res, copySubscriptionInfo(subInfo))
+				break
+			}
+		}
+	}
+
+	return res, nil
+}
+
+func (ms *MemoryStorage) GetSubscriptionInfo(ctx context.Context, chatId int64) (SubscriptionInfo, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	subInfo, ok := ms.subscriptions[chatId]
+	if !ok {
+		return SubscriptionInfo{}, fmt.Errorf("Subscription info for chat %d wasn't found", chatId)
+	}
+
+	return copySubscriptionInfo(subInfo), nil
+}
+
+func (ms *MemoryStorage) UnsubscribeUser(ctx context.Context, subscriptionId string, chatId int64) (bool, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	subInfo, ok := ms.subscriptions[chatId]
+	if !ok {
+		return false, fmt.Errorf("Subscription info for chat %d wasn't found", chatId)
+	}
+
+	subInfo = copySubscriptionInfo(subInfo)
+	if subInfo.Subscriptions, ok = removeSubscription(subInfo.Subscriptions, subscriptionId); !ok {
+		return false, nil
+	}
+
+	ms.subscriptions[chatId] = subInfo
+	return true, nil
+}
+
+func (ms *MemoryStorage) UpdateSubscription(ctx context.Context, chatId int64, sub SubscriptionCategory) (bool, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	subInfo, ok := ms.subscriptions[chatId]
+	if !ok {
+		return false, fmt.Errorf("Subscription info for chat %d wasn't found", chatId)
+	}
+
+	subInfo = copySubscriptionInfo(subInfo)
+	if isFound, err := replaceSubscription(subInfo.Subscriptions, sub); !isFound || err != nil {
+		return false, err
+	}
+
+	ms.subscriptions[chatId] = subInfo
+	return true, nil
+}
+
+func (ms *MemoryStorage) SubscribeUser(ctx context.Context, subCategory SubscriptionCategory, userId int, chatId int64, userName string) (bool, error) {
+	added, err := ms.SubscribeMany(ctx, []SubscriptionCategory{subCategory}, userId, chatId, userName)
+	return added > 0, err
+}
+
+func (ms *MemoryStorage) SubscribeMany(ctx context.Context, subs []SubscriptionCategory, userId int, chatId int64, userName string) (int, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	res, ok := ms.subscriptions[chatId]
+	res = copySubscriptionInfo(res)
+	res.ChatId = chatId
+	res.UserName = userName
+	if !ok {
+		fmt.Println("User doesn't exist, creating...")
+		res.UserId = userId
+		res.CreateDate = time.Now().UTC().Format(time.RFC1123Z)
+		fmt.Printf("User with name %s created\n", userName)
+	}
+
+	var added int
+	res.Subscriptions, added = appendNewSubscriptions(res.Subscriptions, subs)
+	ms.subscriptions[chatId] = res
+
+	return added, nil
+}
+
+func (ms *MemoryStorage) SetLastTimeCheckedUTC(ctx context.Context, category DouCategory, exp string, checked time.Time) error {
+	ms.updateCategoryInfo(category, exp, func(c *CategoryInfo) {
+		c.LastTimeChecked = checked.UTC().Format(time.RFC1123Z)
+	})
+	return nil
+}
+
+func (ms *MemoryStorage) SetFeedValidators(ctx context.Context, category DouCategory, exp string, validators FeedValidators) error {
+	ms.updateCategoryInfo(category, exp, func(c *CategoryInfo) {
+		c.FeedValidators = validators
+	})
+	return nil
+}
+
+func (ms *MemoryStorage) GetFeedValidators(ctx context.Context, category DouCategory, exp string) (FeedValidators, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	return ms.categories[categoryKey(category.id, exp)].FeedValidators, nil
+}
+
+func (ms *MemoryStorage) updateCategoryInfo(category DouCategory, exp string, update func(c *CategoryInfo)) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	key := categoryKey(category.id, exp)
+	c := ms.categories[key]
+	c.IDCategory, c.NameCategory, c.Experience = IdToDBId(category.id), category.name, IdToDBId(exp)
+	update(&c)
+	ms.categories[key] = c
+}
+
+func (ms *MemoryStorage) GetLastTimeCheckedUTC(ctx context.Context, category DouCategory, exp string) (time.Time, error) {
+	ms.lock.RLock()
+	doc, ok := ms.categories[categoryKey(category.id, exp)]
+	ms.lock.RUnlock()
+
+	if !ok {
+		fmt.Printf("Category %s:id[%s]:exp[%s] wasn't checked yet\n", category.name, category.id, IdToDBId(exp))
+		return time.Time{}, nil
+	}
+
+	tm, err := time.Parse(time.RFC1123Z, doc.LastTimeChecked)
+	if err != nil {
+		fmt.Printf("Error parsing %s to time\n", doc.LastTimeChecked)
+		return time.Time{}, nil
+	}
+
+	return tm, nil
+}
+
+func (ms *MemoryStorage) MarkVacancySent(ctx context.Context, chatId int64, vacancyUrl string) (bool, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	key := sentVacancyKey(chatId, vacancyUrl)
+	if _, ok := ms.sentVacancies[key]; ok {
+		return false, nil
+	}
+
+	ms.sentVacancies[key] = time.Now().UTC()
+	return true, nil
+}
+
+func (ms *MemoryStorage) UnmarkVacancySent(ctx context.Context, chatId int64, vacancyUrl string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	delete(ms.sentVacancies, sentVacancyKey(chatId, vacancyUrl))
+	return nil
+}
+
+func (ms *MemoryStorage) RemoveSentVacanciesBefore(ctx context.Context, before time.Time) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	for key, sentDate := range ms.sentVacancies {
+		if sentDate.Before(before) {
+			delete(ms.sentVacancies, key)
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemoryStorage) SetDeliveryMode(ctx context.Context, chatId int64, mode string, digestHour int) error {
+	return ms.updateSubscriptionInfo(chatId, func(subInfo *SubscriptionInfo) {
+		subInfo.DeliveryMode = mode
+		subInfo.DigestHour = digestHour
+		subInfo.LastDigestDate = time.Now().UTC().Format(time.RFC1123Z)
+	})
+}
+
+func (ms *MemoryStorage) SetLastDigestDate(ctx context.Context, chatId int64, date time.Time) error {
+	return ms.updateSubscriptionInfo(chatId, func(subInfo *SubscriptionInfo) {
+		subInfo.LastDigestDate = date.UTC().Format(time.RFC1123Z)
+	})
+}
+
+func (ms *MemoryStorage) SetQuietHours(ctx context.Context, chatId int64, timezone string, quietFrom int, quietTo int) error {
+	return ms.updateSubscriptionInfo(chatId, func(subInfo *SubscriptionInfo) {
+		subInfo.Timezone = timezone
+		subInfo.QuietFrom = quietFrom
+		subInfo.QuietTo = quietTo
+	})
+}
+
+func (ms *MemoryStorage) SaveCategories(ctx context.Context, categories []CategoryRecord) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	ms.categoryList = append([]CategoryRecord(nil), categories...)
+	return nil
+}
+
+func (ms *MemoryStorage) GetCategories(ctx context.Context) ([]CategoryRecord, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	return append([]CategoryRecord{}, ms.categoryList...), nil
+}
+
+func (ms *MemoryStorage) RenameCategory(ctx context.Context, categoryId string, name string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	for chatId, subInfo := range ms.subscriptions {
+		subInfo = copySubscriptionInfo(subInfo)
+		if renameSubscriptions(subInfo.Subscriptions, categoryId, name) {
+			ms.subscriptions[chatId] = subInfo
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) SetChannelLink(ctx context.Context, link ChannelLink) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	ms.channelLinks[link.ChatId] = link
+	return nil
+}
+
+func (ms *MemoryStorage) GetChannelLink(ctx context.Context, chatId int64) (ChannelLink, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	return ms.channelLinks[chatId], nil
+}
+
+func (ms *MemoryStorage) RemoveChannelLink(ctx context.Context, chatId int64) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	delete(ms.channelLinks, chatId)
+	return nil
+}
+
+func (ms *MemoryStorage) DeactivateChat(ctx context.Context, chatId int64, reason string, date time.Time) error {
+	return ms.updateSubscriptionInfo(chatId, func(subInfo *SubscriptionInfo) {
+		subInfo.Inactive = true
+		subInfo.InactiveReason = reason
+		subInfo.InactiveDate = date.UTC().Format(time.RFC1123Z)
+	})
+}
+
+func (ms *MemoryStorage) ReactivateChat(ctx context.Context, chatId int64) (bool, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	subInfo, ok := ms.subscriptions[chatId]
+	if !ok || !subInfo.Inactive {
+		return false, nil
+	}
+
+	subInfo.Inactive, subInfo.InactiveReason, subInfo.InactiveDate = false, "", ""
+	ms.subscriptions[chatId] = subInfo
+	return true, nil
+}
+
+func (ms *MemoryStorage) AddPendingVacancy(ctx context.Context, chatId int64, vacancy VacancyRecord) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	for _, pending := range ms.pending[chatId] {
+		if pending.Vacancy.Url == vacancy.Url {
+			return nil
+		}
+	}
+	ms.pending[chatId] = append(ms.pending[chatId], PendingVacancy{ChatId: chatId, Vacancy: vacancy, AddDate: time.Now().UTC()})
+	return nil
+}
+
+func (ms *MemoryStorage) GetPendingVacancies(ctx context.Context, chatId int64) ([]VacancyRecord, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	res := []VacancyRecord{}
+	for _, pending := range ms.pending[chatId] {
+		res = append(res, pending.Vacancy)
+	}
+	return res, nil
+}
+
+func (ms *MemoryStorage) RemovePendingVacancies(ctx context.Context, chatId int64, urls []string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	left := []PendingVacancy{}
+	for _, pending := range ms.pending[chatId] {
+		if indexOf(urls, pending.Vacancy.Url) == -1 {
+			left = append(left, pending)
+		}
+	}
+
+	if len(left) == 0 {
+		delete(ms.pending, chatId)
+	} else {
+		ms.pending[chatId] = left
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) GetPendingChats(ctx context.Context) (map[int64]time.Time, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	res := map[int64]time.Time{}
+	for chatId, pending := range ms.pending {
+		res[chatId] = pending[0].AddDate
+	}
+	return res, nil
+}
+
+func (ms *MemoryStorage) EnqueueMessage(ctx context.Context, msg OutboxMessage) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	if msg.ID == "" {
+		msg.ID = newOutboxId()
+	}
+	if _, ok := ms.outbox[msg.ID]; ok {
+		return nil
+	}
+	ms.outbox[msg.ID] = msg
+	return nil
+}
+
+func (ms *MemoryStorage) GetDueMessages(ctx context.Context, now time.Time, limit int) ([]OutboxMessage, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	res := []OutboxMessage{}
+	for _, msg := range ms.outbox {
+		if !msg.NextAttempt.After(now) {
+			res = append(res, msg)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (ms *MemoryStorage) RescheduleMessage(ctx context.Context, id string, attempts int, nextAttempt time.Time) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	msg, ok := ms.outbox[id]
+	if !ok {
+		return fmt.Errorf("Outbox message %s wasn't found", id)
+	}
+
+	msg.Attempts = attempts
+	msg.NextAttempt = nextAttempt
+	ms.outbox[id] = msg
+	return nil
+}
+
+func (ms *MemoryStorage) RemoveMessage(ctx context.Context, id string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	delete(ms.outbox, id)
+	return nil
+}
+
+func (ms *MemoryStorage) CountMessages(ctx context.Context) (int, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	return len(ms.outbox), nil
+}
+
+func (ms *MemoryStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (ms *MemoryStorage) updateSubscriptionInfo(chatId int64, update func(subInfo *SubscriptionInfo)) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	subInfo, ok := ms.subscriptions[chatId]
+	if !ok {
+		return fmt.Errorf("Subscription info for chat %d wasn't found", chatId)
+	}
+
+	subInfo = copySubscriptionInfo(subInfo)
+	update(&subInfo)
+	ms.subscriptions[chatId] = subInfo
+	return nil
+}
+
+func sentVacancyKey(chatId int64, vacancyUrl string) string {
+	return strconv.FormatInt(chatId, 10) + "/" + vacancyUrl
+}
+
+func categoryKey(categoryId string, exp string) string {
+	return IdToDBId(categoryId) + "/" + IdToDBId(exp)
+}
+
+// copySubscriptionInfo makes sure callers never share the subscriptions slice with the storage
+func copySubscriptionInfo(subInfo SubscriptionInfo) SubscriptionInfo {
+	subInfo.Subscriptions = append([]SubscriptionCategory(nil), subInfo.Subscriptions...)
+	return subInfo
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	scrapesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dou_scrapes_total",
+		Help: "Feed requests made per category.",
+	}, []string{"category"})
+	scrapeErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dou_scrape_errors_total",
+		Help: "Feed requests failed per category.",
+	}, []string{"category"})
+	scrapeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dou_scrape_duration_seconds",
+		Help:    "Time spent fetching and parsing a feed per category.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"category"})
+	sweepDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "dou_sweep_duration_seconds",
+		Help:    "Time spent scraping all feeds once.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+	})
+	vacanciesDetectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dou_vacancies_detected_total",
+		Help: "New vacancies found in feeds per category.",
+	}, []string{"category"})
+	breakerStateGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "dou_circuit_breaker_state",
+		Help: "State of DOU circuit breaker: 0 closed, 1 open, 2 half-open.",
+	})
+	deliveriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "telegram_deliveries_total",
+		Help: "Messages sent from the outbox by result, sent or failed.",
+	}, []string{"result"})
+	outboxDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "telegram_outbox_messages",
+		Help: "Messages waiting in the outbox.",
+	})
+	activeSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "telegram_active_sessions",
+		Help: "Chat sessions currently kept by the dispatcher.",
+	})
+)
+
+func observeScrape(category DouCategory, duration time.Duration, err error) {
+	scrapesTotal.WithLabelValues(category.name).Inc()
+	scrapeDuration.WithLabelValues(category.name).Observe(duration.Seconds())
+	if err != nil {
+		scrapeErrorsTotal.WithLabelValues(category.name).Inc()
+	}
+}
+
+func observeDelivery(err error) {
+	if err != nil {
+		deliveriesTotal.WithLabelValues("failed").Inc()
+		return
+	}
+	deliveriesTotal.WithLabelValues("sent").Inc()
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
 	"time"
 
@@ -12,158 +11,721 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// mongoTimeout limits every call to mongo, so a stuck connection can't block deliveries forever
+const mongoTimeout = 10 * time.Second
+
 type MongoStorage struct {
 	client                  *mongo.Client
 	categoriesCollection    *mongo.Collection
 	subscriptionsCollection *mongo.Collection
+	sentVacanciesCollection *mongo.Collection
+	pendingCollection       *mongo.Collection
+	outboxCollection        *mongo.Collection
+	categoryListCollection  *mongo.Collection
+	channelLinksCollection  *mongo.Collection
 }
 
-func CreateMongoStorage() (*MongoStorage, error) {
+func CreateMongoStorage(ctx context.Context) (*MongoStorage, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
 	serverAPIOptions := options.ServerAPI(options.ServerAPIVersion1)
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(os.Getenv("MONGO")).SetServerAPIOptions(serverAPIOptions))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(os.Getenv("MONGO")).SetServerAPIOptions(serverAPIOptions))
 	if err != nil {
 		return nil, err
 	}
 	fmt.Println(client)
 
-	return &MongoStorage{
+	ms := &MongoStorage{
 		client:                  client,
 		categoriesCollection:    client.Database("dou").Collection("categories"),
 		subscriptionsCollection: client.Database("dou").Collection("subscriptions"),
-	}, nil
+		sentVacanciesCollection: client.Database("dou").Collection("sentVacancies"),
+		pendingCollection:       client.Database("dou").Collection("pendingVacancies"),
+		outboxCollection:        client.Database("dou").Collection("outbox"),
+		categoryListCollection:  client.Database("dou").Collection("categoryList"),
+		channelLinksCollection:  client.Database("dou").Collection("channelLinks"),
+	}
+
+	_, err = ms.sentVacanciesCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "chatId", Value: 1}, {Key: "url", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "sentDate", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(sentVacancyTTL.Seconds())),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ms.outboxCollection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "nextAttempt", Value: 1}}})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ms.migrateSubscriptionIds(ctx); err != nil {
+		return nil, err
+	}
+
+	// pending vacancies used to be keyed by user id, for private chats it equals chat id
+	pendingFilter := bson.M{"chatId": bson.M{"$exists": false}}
+	pendingUpdate := mongo.Pipeline{{{Key: "$set", Value: bson.M{"chatId": "$userId"}}}, {{Key: "$unset", Value: "userId"}}}
+	if _, err := ms.pendingCollection.UpdateMany(ctx, pendingFilter, pendingUpdate); err != nil {
+		return nil, err
+	}
+
+	// unique index makes upserts of AddPendingVacancy safe from racing, duplicates queued before it have to go first
+	if err := ms.removeDuplicatePendingVacancies(ctx); err != nil {
+		return nil, err
+	}
+	_, err = ms.pendingCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "chatId", Value: 1}, {Key: "vacancy.url", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ms, nil
+}
+
+// removeDuplicatePendingVacancies keeps the first queued copy of every vacancy queued for a chat several times
+func (ms *MongoStorage) removeDuplicatePendingVacancies(ctx context.Context) error {
+	coll := ms.pendingCollection
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.M{"addDate": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"chatId": "$chatId", "url": "$vacancy.url"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+
+	docs := []struct {
+		IDs []interface{} `bson:"ids"`
+	}{}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		if _, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": doc.IDs[1:]}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSubscriptionIds gives ids to subscriptions created before ids were introduced
+func (ms *MongoStorage) migrateSubscriptionIds(ctx context.Context) error {
+	coll := ms.subscriptionsCollection
+	filter := bson.M{"subscriptions": bson.M{"$elemMatch": bson.M{"id": bson.M{"$exists": false}}}}
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	subInfos := []SubscriptionInfo{}
+	if err := cursor.All(ctx, &subInfos); err != nil {
+		return err
+	}
+
+	for _, subInfo := range subInfos {
+		assignSubscriptionIds(subInfo.Subscriptions)
+		if _, err := coll.ReplaceOne(ctx, bson.D{{Key: "chatId", Value: subInfo.ChatId}}, subInfo); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (ms *MongoStorage) GetAllSubscribers(categoryName string, categoryId string, exp string) ([]SubscriptionInfo, error) {
+func (ms *MongoStorage) Close(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	return ms.client.Disconnect(ctx)
+}
+
+func (ms *MongoStorage) GetAllSubscribers(ctx context.Context, categoryName string, categoryId string, exp string) ([]SubscriptionInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
 	coll := ms.subscriptionsCollection
-	filter := bson.M{"subscriptions": bson.D{{"idCategory", IdToDBId(categoryId)}, {"nameCategory", categoryName}, {"experience", IdToDBId(exp)}}}
+	filter := bson.M{
+		"subscriptions": bson.M{"$elemMatch": bson.M{"idCategory": IdToDBId(categoryId), "experience": IdToDBId(exp)}},
+		"inactive":      bson.M{"$ne": true},
+	}
 	res := []SubscriptionInfo{}
-	cursor, err := coll.Find(context.TODO(), filter)
+	cursor, err := coll.Find(ctx, filter)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	if err = cursor.All(context.TODO(), &res); err != nil {
-		log.Fatal(err)
+	if err = cursor.All(ctx, &res); err != nil {
+		return nil, err
 	}
 
 	return res, nil
 }
-func (ms *MongoStorage) GetSubscriptionInfo(userId int) (SubscriptionInfo, error) {
+func (ms *MongoStorage) GetSubscriptionInfo(ctx context.Context, chatId int64) (SubscriptionInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
 	coll := ms.subscriptionsCollection
-	filter := bson.D{{Key: "userId", Value: userId}}
+	filter := bson.D{{Key: "chatId", Value: chatId}}
 	var res SubscriptionInfo
-	err := coll.FindOne(context.TODO(), filter).Decode(&res)
+	err := coll.FindOne(ctx, filter).Decode(&res)
 	return res, err
 }
 
-func (ms *MongoStorage) UnsubscribeUser(categoryName string, userId int) (bool, error) {
-	subInfo, err := ms.GetSubscriptionInfo(userId)
+func (ms *MongoStorage) UnsubscribeUser(ctx context.Context, subscriptionId string, chatId int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	subInfo, err := ms.GetSubscriptionInfo(ctx, chatId)
 	if err != nil {
 		return false, err
 	}
 
 	isFound := false
-	for id, sub := range subInfo.Subscriptions {
-		if sub.NameCategory == categoryName {
-			isFound = true
-			subInfo.Subscriptions = remove(subInfo.Subscriptions, id)
-			break
-		}
+	if subInfo.Subscriptions, isFound = removeSubscription(subInfo.Subscriptions, subscriptionId); !isFound {
+		return false, nil
 	}
 
-	if !isFound {
-		return false, nil
+	coll := ms.subscriptionsCollection
+	filter := bson.D{{Key: "chatId", Value: chatId}}
+	if _, err := coll.ReplaceOne(ctx, filter, subInfo); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (ms *MongoStorage) UpdateSubscription(ctx context.Context, chatId int64, sub SubscriptionCategory) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	subInfo, err := ms.GetSubscriptionInfo(ctx, chatId)
+	if err != nil {
+		return false, err
+	}
+
+	if isFound, err := replaceSubscription(subInfo.Subscriptions, sub); !isFound || err != nil {
+		return false, err
 	}
 
 	coll := ms.subscriptionsCollection
-	filter := bson.D{{Key: "userId", Value: userId}}
-	if _, err := coll.ReplaceOne(context.TODO(), filter, subInfo); err != nil {
+	filter := bson.D{{Key: "chatId", Value: chatId}}
+	if _, err := coll.ReplaceOne(ctx, filter, subInfo); err != nil {
 		return false, err
 	}
 
 	return true, nil
 }
 
-func (ms *MongoStorage) SubscribeUser(category DouCategory, exp string, userId int, chatId int64, userName string) (bool, error) {
+func (ms *MongoStorage) SubscribeUser(ctx context.Context, subCategory SubscriptionCategory, userId int, chatId int64, userName string) (bool, error) {
+	added, err := ms.SubscribeMany(ctx, []SubscriptionCategory{subCategory}, userId, chatId, userName)
+	return added > 0, err
+}
+
+func (ms *MongoStorage) SubscribeMany(ctx context.Context, subs []SubscriptionCategory, userId int, chatId int64, userName string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
 	coll := ms.subscriptionsCollection
-	filter := bson.D{{Key: "userId", Value: userId}}
-	subCategory := SubscriptionCategory{IDCategory: IdToDBId(category.id), NameCategory: category.name, Experience: IdToDBId(exp)}
+	filter := bson.D{{Key: "chatId", Value: chatId}}
 	var res SubscriptionInfo
-	coll.FindOne(context.TODO(), filter).Decode(&res)
+	err := coll.FindOne(ctx, filter).Decode(&res)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return 0, err
+	}
 	res.ChatId = chatId
 	res.UserName = userName
-	if res.UserId == 0 {
-		fmt.Println("User doesn't exist, creating...")
+
+	var added int
+	res.Subscriptions, added = appendNewSubscriptions(res.Subscriptions, subs)
+	if err == mongo.ErrNoDocuments {
+		fmt.Println("Chat doesn't exist, creating...")
 		res.UserId = userId
 		res.CreateDate = time.Now().UTC().Format(time.RFC1123Z)
-		res.Subscriptions = []SubscriptionCategory{subCategory}
-		if _, err := coll.InsertOne(context.TODO(), res); err != nil {
-			return false, err
+		if _, err := coll.InsertOne(ctx, res); err != nil {
+			return 0, err
 		}
 		fmt.Printf("User with name %s created\n", userName)
-		return true, nil
+		return added, nil
 	}
 
-	for _, alreadySubCat := range res.Subscriptions {
-		if alreadySubCat.IDCategory == IdToDBId(category.id) {
-			return false, nil
-		}
+	if added == 0 {
+		return 0, nil
 	}
 
-	res.Subscriptions = append(res.Subscriptions, subCategory)
-	if _, err := coll.ReplaceOne(context.TODO(), filter, res); err != nil {
-		return false, err
+	if _, err := coll.ReplaceOne(ctx, filter, res); err != nil {
+		return 0, err
 	}
 
-	return true, nil
+	return added, nil
 }
 
-func (ms *MongoStorage) SetLastTimeCheckedUTC(category DouCategory, exp string) error {
-	coll := ms.categoriesCollection
-	c := &CategoryInfo{
-		IDCategory:      IdToDBId(category.id),
-		NameCategory:    category.name,
-		Experience:      IdToDBId(exp),
-		LastTimeChecked: time.Now().UTC().Format(time.RFC1123Z),
-	}
+func (ms *MongoStorage) SetLastTimeCheckedUTC(ctx context.Context, category DouCategory, exp string, checked time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
 
-	filter := bson.D{{Key: "idCategory", Value: c.IDCategory}, {Key: "experience", Value: IdToDBId(exp)}}
-	result, err := coll.ReplaceOne(context.TODO(), filter, c)
+	err := ms.updateCategoryInfo(ctx, category, exp, bson.M{"lastTimeChecked": checked.UTC().Format(time.RFC1123Z)})
 	if err != nil {
-		fmt.Println(err)
 		return err
 	}
+	fmt.Printf("Replaced lastTimeUsed for category `%v` exp[%s]\n", category.name, IdToDBId(exp))
+	return nil
+}
 
-	if result.MatchedCount == 0 {
-		res, err := coll.InsertOne(context.TODO(), c)
-		if err != nil {
-			return err
-		}
-		fmt.Printf("Added category %v exp[%s] with id: %v\n", category.name, IdToDBId(exp), res.InsertedID)
-		return nil
+func (ms *MongoStorage) SetFeedValidators(ctx context.Context, category DouCategory, exp string, validators FeedValidators) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	return ms.updateCategoryInfo(ctx, category, exp, bson.M{
+		"etag":         validators.ETag,
+		"lastModified": validators.LastModified,
+		"contentHash":  validators.ContentHash,
+	})
+}
+
+func (ms *MongoStorage) GetFeedValidators(ctx context.Context, category DouCategory, exp string) (FeedValidators, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	coll := ms.categoriesCollection
+	filter := bson.D{{Key: "idCategory", Value: IdToDBId(category.id)}, {Key: "experience", Value: IdToDBId(exp)}}
+	var doc CategoryInfo
+	if err := coll.FindOne(ctx, filter).Decode(&doc); err != nil && err != mongo.ErrNoDocuments {
+		return FeedValidators{}, err
 	}
-	fmt.Printf("Replaced lastTimeUsed for category `%v` matches: %v\n", category.name, result.MatchedCount)
+	return doc.FeedValidators, nil
+}
 
-	return nil
+// updateCategoryInfo sets fields of the category info, creating it when category is checked for the first time
+func (ms *MongoStorage) updateCategoryInfo(ctx context.Context, category DouCategory, exp string, fields bson.M) error {
+	coll := ms.categoriesCollection
+	filter := bson.D{{Key: "idCategory", Value: IdToDBId(category.id)}, {Key: "experience", Value: IdToDBId(exp)}}
+	fields["nameCategory"] = category.name
+	_, err := coll.UpdateOne(ctx, filter, bson.M{"$set": fields}, options.Update().SetUpsert(true))
+	return err
 }
-func (ms *MongoStorage) GetLastTimeCheckedUTC(category DouCategory, exp string) time.Time {
+
+func (ms *MongoStorage) GetLastTimeCheckedUTC(ctx context.Context, category DouCategory, exp string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
 	coll := ms.categoriesCollection
 	filter := bson.D{{Key: "idCategory", Value: IdToDBId(category.id)}, {Key: "experience", Value: IdToDBId(exp)}}
 
 	var doc CategoryInfo
-	result := coll.FindOne(context.TODO(), filter)
-	if err := result.Decode(&doc); err != nil {
-		fmt.Printf("Category %s:id[%s]:exp[%s] wasn't found, so using current time\n", category.name, category.id, IdToDBId(exp))
-		return time.Now().UTC()
+	result := coll.FindOne(ctx, filter)
+	if err := result.Decode(&doc); err == mongo.ErrNoDocuments {
+		fmt.Printf("Category %s:id[%s]:exp[%s] wasn't checked yet\n", category.name, category.id, IdToDBId(exp))
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
 	}
 
 	tm, err := time.Parse(time.RFC1123Z, fmt.Sprint(doc.LastTimeChecked))
 	if err != nil {
 		fmt.Printf("Error parsing %s to time\n", doc.LastTimeChecked)
-		return time.Now().UTC()
+		return time.Time{}, nil
 	}
 
-	return tm //time.Date(2023, time.March, 17, 18, 0, 0, 0, time.Now().Location()).UTC() //
+	return tm, nil
+}
+
+func (ms *MongoStorage) MarkVacancySent(ctx context.Context, chatId int64, vacancyUrl string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	coll := ms.sentVacanciesCollection
+	_, err := coll.InsertOne(ctx, SentVacancy{ChatId: chatId, Url: vacancyUrl, SentDate: time.Now().UTC()})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (ms *MongoStorage) UnmarkVacancySent(ctx context.Context, chatId int64, vacancyUrl string) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	_, err := ms.sentVacanciesCollection.DeleteOne(ctx, bson.M{"chatId": chatId, "url": vacancyUrl})
+	return err
+}
+
+func (ms *MongoStorage) RemoveSentVacanciesBefore(ctx context.Context, before time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	coll := ms.sentVacanciesCollection
+	_, err := coll.DeleteMany(ctx, bson.M{"sentDate": bson.M{"$lt": before}})
+	return err
+}
+
+func (ms *MongoStorage) SetDeliveryMode(ctx context.Context, chatId int64, mode string, digestHour int) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	return ms.updateSubscriptionInfo(ctx, chatId, bson.M{
+		"deliveryMode":   mode,
+		"digestHour":     digestHour,
+		"lastDigestDate": time.Now().UTC().Format(time.RFC1123Z),
+	})
+}
+
+func (ms *MongoStorage) SetLastDigestDate(ctx context.Context, chatId int64, date time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	return ms.updateSubscriptionInfo(ctx, chatId, bson.M{"lastDigestDate": date.UTC().Format(time.RFC1123Z)})
+}
+
+func (ms *MongoStorage) SetQuietHours(ctx context.Context, chatId int64, timezone string, quietFrom int, quietTo int) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	return ms.updateSubscriptionInfo(ctx, chatId, bson.M{"timezone": timezone, "quietFrom": quietFrom, "quietTo": quietTo})
+}
+
+func (ms *MongoStorage) SaveCategories(ctx context.Context, categories []CategoryRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	coll := ms.categoryListCollection
+	if _, err := coll.DeleteMany(ctx, bson.M{}); err != nil {
+		return err
+	}
+	if len(categories) == 0 {
+		return nil
+	}
+
+	docs := []interface{}{}
+	for i, category := range categories {
+		category.Order = i
+		docs = append(docs, category)
+	}
+	_, err := coll.InsertMany(ctx, docs)
+	return err
+}
+
+func (ms *MongoStorage) GetCategories(ctx context.Context) ([]CategoryRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	cursor, err := ms.categoryListCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "order", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	res := []CategoryRecord{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (ms *MongoStorage) RenameCategory(ctx context.Context, categoryId string, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	coll := ms.subscriptionsCollection
+	// it is called for every category on each refresh, so only documents with the outdated name are matched
+	filter := bson.M{"subscriptions": bson.M{"$elemMatch": bson.M{"idCategory": IdToDBId(categoryId), "nameCategory": bson.M{"$ne": name}}}}
+	update := bson.M{"$set": bson.M{"subscriptions.$[sub].nameCategory": name}}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"sub.idCategory": IdToDBId(categoryId)}}})
+	_, err := coll.UpdateMany(ctx, filter, update, opts)
+	return err
+}
+
+func (ms *MongoStorage) SetChannelLink(ctx context.Context, link ChannelLink) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	coll := ms.channelLinksCollection
+	_, err := coll.ReplaceOne(ctx, bson.M{"_id": link.ChatId}, link, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (ms *MongoStorage) GetChannelLink(ctx context.Context, chatId int64) (ChannelLink, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	link := ChannelLink{}
+	err := ms.channelLinksCollection.FindOne(ctx, bson.M{"_id": chatId}).Decode(&link)
+	if err == mongo.ErrNoDocuments {
+		return ChannelLink{}, nil
+	}
+	return link, err
+}
+
+func (ms *MongoStorage) RemoveChannelLink(ctx context.Context, chatId int64) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	_, err := ms.channelLinksCollection.DeleteOne(ctx, bson.M{"_id": chatId})
+	return err
+}
+
+func (ms *MongoStorage) DeactivateChat(ctx context.Context, chatId int64, reason string, date time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	return ms.updateSubscriptionInfo(ctx, chatId, bson.M{"inactive": true, "inactiveReason": reason, "inactiveDate": date.UTC().Format(time.RFC1123Z)})
+}
+
+func (ms *MongoStorage) ReactivateChat(ctx context.Context, chatId int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	coll := ms.subscriptionsCollection
+	filter := bson.D{{Key: "chatId", Value: chatId}, {Key: "inactive", Value: true}}
+	update := bson.M{"$unset": bson.M{"inactive": "", "inactiveReason": "", "inactiveDate": ""}}
+	result, err := coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (ms *MongoStorage) AddPendingVacancy(ctx context.Context, chatId int64, vacancy VacancyRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	coll := ms.pendingCollection
+	filter := bson.M{"chatId": chatId, "vacancy.url": vacancy.Url}
+	update := bson.M{"$setOnInsert": bson.M{"vacancy": vacancy, "addDate": time.Now().UTC()}}
+	_, err := coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (ms *MongoStorage) GetPendingVacancies(ctx context.Context, chatId int64) ([]VacancyRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	coll := ms.pendingCollection
+	filter := bson.D{{Key: "chatId", Value: chatId}}
+	cursor, err := coll.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "addDate", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	docs := []PendingVacancy{}
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	res := []VacancyRecord{}
+	for _, doc := range docs {
+		res = append(res, doc.Vacancy)
+	}
+	return res, nil
+}
+
+func (ms *MongoStorage) RemovePendingVacancies(ctx context.Context, chatId int64, urls []string) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	_, err := ms.pendingCollection.DeleteMany(ctx, bson.M{"chatId": chatId, "vacancy.url": bson.M{"$in": urls}})
+	return err
+}
+
+func (ms *MongoStorage) GetPendingChats(ctx context.Context) (map[int64]time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	coll := ms.pendingCollection
+	pipeline := mongo.Pipeline{{{Key: "$group", Value: bson.M{"_id": "$chatId", "since": bson.M{"$min": "$addDate"}}}}}
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := []struct {
+		ChatId int64     `bson:"_id"`
+		Since  time.Time `bson:"since"`
+	}{}
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	res := map[int64]time.Time{}
+	for _, doc := range docs {
+		res[doc.ChatId] = doc.Since
+	}
+	return res, nil
+}
+
+func (ms *MongoStorage) EnqueueMessage(ctx context.Context, msg OutboxMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	if msg.ID == "" {
+		msg.ID = newOutboxId()
+	}
+	_, err := ms.outboxCollection.InsertOne(ctx, msg)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func (ms *MongoStorage) GetDueMessages(ctx context.Context, now time.Time, limit int) ([]OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	coll := ms.outboxCollection
+	filter := bson.M{"nextAttempt": bson.M{"$lte": now}}
+	// ids are object ids in hex, so sorting by them keeps creation order
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []OutboxMessage{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (ms *MongoStorage) RescheduleMessage(ctx context.Context, id string, attempts int, nextAttempt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"attempts": attempts, "nextAttempt": nextAttempt}}
+	_, err := ms.outboxCollection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (ms *MongoStorage) RemoveMessage(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	_, err := ms.outboxCollection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (ms *MongoStorage) CountMessages(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	count, err := ms.outboxCollection.CountDocuments(ctx, bson.M{})
+	return int(count), err
+}
+
+func (ms *MongoStorage) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	return ms.client.Ping(ctx, nil)
+}
+
+func (ms *MongoStorage) updateSubscriptionInfo(ctx context.Context, chatId int64, fields bson.M) error {
+	ctx, cancel := context.WithTimeout(ctx, mongoTimeout)
+	defer cancel()
+
+	coll := ms.subscriptionsCollection
+	filter := bson.D{{Key: "chatId", Value: chatId}}
+	result, err := coll.UpdateOne(ctx, filter, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("Subscription info for chat %d wasn't found", chatId)
+	}
+	return nil
+}
+
+// renameSubscriptions sets new name to subscriptions of the category, it returns false when there are none
+func renameSubscriptions(subs []SubscriptionCategory, categoryId string, name string) bool {
+	isRenamed := false
+	for i := range subs {
+		if subs[i].IDCategory == IdToDBId(categoryId) && subs[i].NameCategory != name {
+			subs[i].NameCategory = name
+			isRenamed = true
+		}
+	}
+	return isRenamed
+}
+
+// appendNewSubscriptions adds subscriptions which aren't present yet, the same category and experience can be subscribed with other filters
+func appendNewSubscriptions(existing []SubscriptionCategory, subs []SubscriptionCategory) ([]SubscriptionCategory, int) {
+	added := 0
+	for _, sub := range subs {
+		isFound := false
+		for _, alreadySubCat := range existing {
+			if alreadySubCat.sameAs(sub) {
+				isFound = true
+				break
+			}
+		}
+
+		if !isFound {
+			if sub.ID == "" {
+				sub.ID = newSubscriptionId()
+			}
+			existing = append(existing, sub)
+			added++
+		}
+	}
+	return existing, added
+}
+
+// replaceSubscription replaces subscription with the same id, it fails when the edited subscription
+// becomes the same as other one
+func replaceSubscription(subs []SubscriptionCategory, sub SubscriptionCategory) (bool, error) {
+	index := -1
+	for id, alreadySubCat := range subs {
+		if alreadySubCat.ID == sub.ID {
+			index = id
+		} else if alreadySubCat.sameAs(sub) {
+			return false, ErrSubscriptionExists
+		}
+	}
+
+	if index == -1 {
+		return false, nil
+	}
+	subs[index] = sub
+	return true, nil
+}
+
+func removeSubscription(subs []SubscriptionCategory, subscriptionId string) ([]SubscriptionCategory, bool) {
+	for id, sub := range subs {
+		if sub.ID == subscriptionId {
+			return remove(subs, id), true
+		}
+	}
+	return subs, false
+}
+
+// assignSubscriptionIds gives ids to subscriptions created before ids were introduced
+func assignSubscriptionIds(subs []SubscriptionCategory) bool {
+	changed := false
+	for id := range subs {
+		if subs[id].ID == "" {
+			subs[id].ID = newSubscriptionId()
+			changed = true
+		}
+	}
+	return changed
 }
 
 func remove[T any](slice []T, s int) []T {
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/NicoNex/echotron/v3"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	defaultOpsPort = "8080"
+	readyTimeout   = 5 * time.Second
+)
+
+// createOpsServer serves health checks and metrics, `/` is kept for hosting which pings it to keep the bot alive
+// and also tells state of DOU circuit breaker
+func createOpsServer(port string, storage *RetryStorage, worker *DouWorker) *http.Server {
+	if port == "" {
+		port = defaultOpsPort
+	}
+
+	api := echotron.NewAPI(token)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		handleStatus(w, r, storage, worker)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		handleHealth(w, r, storage)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		handleReady(w, r, storage, func() error {
+			_, err := api.GetMe()
+			return err
+		})
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+
+	return &http.Server{Addr: ":" + port, Handler: mux}
+}
+
+// handleHealth reports whether storage works, so hosting restarts the bot when storage keeps failing
+func handleHealth(w http.ResponseWriter, r *http.Request, storage *RetryStorage) {
+	if err := storage.Health(); err != nil {
+		writeStatus(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeStatus(w, http.StatusOK, "Success")
+}
+
+// handleStatus fails only with storage like handleHealth, DOU outages are waited out by the breaker
+func handleStatus(w http.ResponseWriter, r *http.Request, storage *RetryStorage, worker *DouWorker) {
+	status, text := http.StatusOK, "storage: ok"
+	if err := storage.Health(); err != nil {
+		status, text = http.StatusServiceUnavailable, fmt.Sprintf("storage: %v", err)
+	}
+	writeStatus(w, status, fmt.Sprintf("%s\nDOU: %s", text, worker.breaker.Status()))
+}
+
+// handleReady checks that storage and telegram can be reached right now
+func handleReady(w http.ResponseWriter, r *http.Request, storage *RetryStorage, getMe func() error) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	if err := storage.Ping(ctx); err != nil {
+		writeStatus(w, http.StatusServiceUnavailable, fmt.Sprintf("storage: %v", err))
+		return
+	}
+	if err := callWithContext(ctx, getMe); err != nil {
+		writeStatus(w, http.StatusServiceUnavailable, fmt.Sprintf("telegram: %v", err))
+		return
+	}
+	writeStatus(w, http.StatusOK, "Ready")
+}
+
+// callWithContext stops waiting for call once ctx is done, echotron requests don't take a context
+func callWithContext(ctx context.Context, call func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func writeStatus(w http.ResponseWriter, status int, text string) {
+	w.Header().Add("Content-Type", "application/text")
+	w.WriteHeader(status)
+	w.Write([]byte(text))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpsServer(t *testing.T) {
+	storage := CreateRetryStorage(CreateMemoryStorage())
+	server := createOpsServer("", storage, CreateDouWorker(storage, "", nil, 0, 0))
+	observeScrape(DouCategory{name: "Golang"}, 0, nil)
+
+	for path, expected := range map[string]string{
+		"/":        "DOU: closed",
+		"/healthz": "Success",
+		"/metrics": `dou_scrapes_total{category="Golang"}`,
+	} {
+		rec := httptest.NewRecorder()
+		server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), expected) {
+			t.Errorf("%s returned %d without %q", path, rec.Code, expected)
+		}
+	}
+}
+
+func TestReadyRespectsTimeout(t *testing.T) {
+	storage := CreateRetryStorage(CreateMemoryStorage())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+	rec := httptest.NewRecorder()
+	handleReady(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil).WithContext(ctx), storage, func() error {
+		<-unblock
+		return nil
+	})
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "telegram") {
+		t.Errorf("hanging telegram check returned %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NicoNex/echotron/v3"
+)
+
+const (
+	outboxWorkers      = 4
+	outboxPollInterval = time.Second
+	outboxBatchSize    = 100
+	outboxMaxAttempts  = 8
+	outboxBaseDelay    = 5 * time.Second
+	outboxMaxDelay     = 10 * time.Minute
+
+	// telegram allows about 30 messages per second overall, one per second to a chat and 20 per minute to a group
+	globalSendInterval  = time.Second / 30
+	privateSendInterval = time.Second
+	groupSendInterval   = 3 * time.Second
+)
+
+var retryAfterRegexp = regexp.MustCompile(`retry after (\d+)`)
+
+// inactiveChatErrors are descriptions of 403 errors after which messages won't ever reach the chat
+var inactiveChatErrors = []string{"bot was blocked by the user", "user is deactivated", "bot was kicked"}
+
+// Outbox sends messages queued in storage, so vacancies aren't lost on restarts and telegram errors
+type Outbox struct {
+	storage Storage
+	api     echotron.API
+	// storageCtx outlives shutdown a bit, so sent messages are still removed from the queue
+	storageCtx context.Context
+
+	lock sync.Mutex
+	// busy chats have a message being sent, so their next message waits to keep the order
+	busy     map[int64]string
+	chatNext map[int64]time.Time
+}
+
+func CreateOutbox(storageCtx context.Context, storage Storage) *Outbox {
+	return &Outbox{
+		storage:    storage,
+		api:        echotron.NewAPI(token),
+		storageCtx: storageCtx,
+		busy:       map[int64]string{},
+		chatNext:   map[int64]time.Time{},
+	}
+}
+
+// Run polls due messages and hands them to workers until ctx is cancelled
+func (o *Outbox) Run(ctx context.Context) {
+	globalTicker := time.NewTicker(globalSendInterval)
+	defer globalTicker.Stop()
+
+	wg := sync.WaitGroup{}
+	jobs := make(chan OutboxMessage, outboxWorkers)
+	for i := 0; i < outboxWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.work(ctx, jobs, globalTicker.C)
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if count, err := o.storage.CountMessages(ctx); err == nil {
+			outboxDepth.Set(float64(count))
+		}
+
+		msgs, err := o.storage.GetDueMessages(ctx, time.Now().UTC(), outboxBatchSize)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		o.dispatch(ctx, msgs, jobs, time.Now())
+	}
+}
+
+// dispatch hands messages of chats which may be sent to now to workers, one message per chat at a time,
+// messages of other chats are rescheduled to the time their chat allows, so they don't fill next batches
+func (o *Outbox) dispatch(ctx context.Context, msgs []OutboxMessage, jobs chan<- OutboxMessage, now time.Time) {
+	deferred := map[int64]int{}
+	for _, msg := range msgs {
+		next, ok := o.take(msg, now)
+		if !ok && next.IsZero() {
+			continue
+		}
+		if !ok {
+			// waiting messages of a chat are spread over its next slots in the order they were queued
+			next = next.Add(time.Duration(deferred[msg.ChatId]) * chatSendInterval(msg.ChatId))
+			deferred[msg.ChatId]++
+			if err := o.storage.RescheduleMessage(ctx, msg.ID, msg.Attempts, next.UTC()); err != nil {
+				fmt.Println(err)
+			}
+			continue
+		}
+
+		select {
+		case jobs <- msg:
+		default:
+			// all workers are busy, the rest of messages is handed out by the next polls
+			o.release(msg.ChatId)
+			return
+		}
+	}
+}
+
+// take reserves chat for sending the message, otherwise it returns when the chat can be sent to,
+// zero time means the message itself is being sent
+func (o *Outbox) take(msg OutboxMessage, now time.Time) (time.Time, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	sending, isBusy := o.busy[msg.ChatId]
+	if sending == msg.ID {
+		return time.Time{}, false
+	}
+
+	next := o.chatNext[msg.ChatId]
+	if isBusy || next.After(now) {
+		if next.Before(now) {
+			next = now
+		}
+		return next, false
+	}
+
+	o.busy[msg.ChatId] = msg.ID
+	o.chatNext[msg.ChatId] = now.Add(chatSendInterval(msg.ChatId))
+	return now, true
+}
+
+func (o *Outbox) release(chatId int64) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	delete(o.busy, chatId)
+}
+
+func chatSendInterval(chatId int64) time.Duration {
+	if isGroupChat(chatId) {
+		return groupSendInterval
+	}
+	return privateSendInterval
+}
+
+func (o *Outbox) work(ctx context.Context, jobs <-chan OutboxMessage, globalTick <-chan time.Time) {
+	for msg := range jobs {
+		select {
+		case <-globalTick:
+			o.send(msg)
+		case <-ctx.Done():
+		}
+		o.release(msg.ChatId)
+	}
+}
+
+func (o *Outbox) delayChat(chatId int64, next time.Time) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.chatNext[chatId] = next
+}
+
+func (o *Outbox) send(msg OutboxMessage) {
+	opts := &echotron.MessageOptions{ParseMode: echotron.HTML, DisableWebPagePreview: msg.DisablePreview}
+	_, err := o.api.SendMessage(msg.Text, msg.ChatId, opts)
+	observeDelivery(err)
+	if err == nil {
+		if err := o.storage.RemoveMessage(o.storageCtx, msg.ID); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	if reason, ok := inactiveChatReason(err); ok {
+		fmt.Printf("Chat %d is inactive: %s\n", msg.ChatId, reason)
+		if err := o.storage.DeactivateChat(o.storageCtx, msg.ChatId, reason, time.Now()); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	attempts, delay, retry := nextAttempt(msg.Attempts, err)
+	if !retry {
+		fmt.Printf("Dropping message to chat %d after %d attempts: %v\n", msg.ChatId, attempts, err)
+		if err := o.storage.RemoveMessage(o.storageCtx, msg.ID); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	fmt.Printf("Sending message to chat %d failed, retrying in %v: %v\n", msg.ChatId, delay, err)
+	// later messages of the chat are rescheduled to the same time, so the failed one still goes first
+	next := time.Now().UTC().Add(delay)
+	o.delayChat(msg.ChatId, next)
+	if err := o.storage.RescheduleMessage(o.storageCtx, msg.ID, attempts, next); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// nextAttempt decides if failed message is worth sending again and when,
+// flood limits are waited out as telegram asks, server and network errors are retried with backoff
+func nextAttempt(attempts int, err error) (int, time.Duration, bool) {
+	var apiErr *echotron.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.ErrorCode() == http.StatusTooManyRequests {
+			return attempts, retryAfter(apiErr.Description()), true
+		}
+		if apiErr.ErrorCode() < http.StatusInternalServerError {
+			return attempts + 1, 0, false
+		}
+	}
+
+	attempts++
+	if attempts >= outboxMaxAttempts {
+		return attempts, 0, false
+	}
+
+	delay := outboxBaseDelay << (attempts - 1)
+	if delay > outboxMaxDelay {
+		delay = outboxMaxDelay
+	}
+	return attempts, delay, true
+}
+
+// inactiveChatReason tells if error means that chat blocked the bot or doesn't exist anymore
+func inactiveChatReason(err error) (string, bool) {
+	var apiErr *echotron.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != http.StatusForbidden {
+		return "", false
+	}
+
+	for _, reason := range inactiveChatErrors {
+		if strings.Contains(apiErr.Description(), reason) {
+			return reason, true
+		}
+	}
+	return "", false
+}
+
+// retryAfter reads delay from descriptions like `Too Many Requests: retry after 35`
+func retryAfter(description string) time.Duration {
+	match := retryAfterRegexp.FindStringSubmatch(description)
+	if match == nil {
+		return outboxBaseDelay
+	}
+
+	seconds, err := strconv.Atoi(match[1])
+	if err != nil {
+		return outboxBaseDelay
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	if delay := retryAfter("Too Many Requests: retry after 35"); delay != 35*time.Second {
+		t.Errorf("expected 35s, got %v", delay)
+	}
+	if delay := retryAfter("Too Many Requests"); delay != outboxBaseDelay {
+		t.Errorf("expected default delay, got %v", delay)
+	}
+}
+
+func TestNextAttemptBacksOffUntilGivingUp(t *testing.T) {
+	err := errors.New("connection reset")
+	attempts, delay, retry := nextAttempt(0, err)
+	if !retry || attempts != 1 || delay != outboxBaseDelay {
+		t.Fatalf("unexpected first retry: %d %v %v", attempts, delay, retry)
+	}
+
+	if _, delay, _ := nextAttempt(2, err); delay != 4*outboxBaseDelay {
+		t.Errorf("expected delay to double, got %v", delay)
+	}
+	if _, _, retry := nextAttempt(outboxMaxAttempts-1, err); retry {
+		t.Error("expected message to be dropped after max attempts")
+	}
+}
+
+func TestMemoryOutboxReturnsDueMessagesInOrder(t *testing.T) {
+	ctx := context.Background()
+	storage := CreateMemoryStorage()
+	now := time.Now().UTC()
+	for _, text := range []string{"first", "second", "later"} {
+		msg := OutboxMessage{ChatId: 1, Text: text, NextAttempt: now}
+		if text == "later" {
+			msg.NextAttempt = now.Add(time.Hour)
+		}
+		if err := storage.EnqueueMessage(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs, err := storage.GetDueMessages(ctx, now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].Text != "first" || msgs[1].Text != "second" {
+		t.Fatalf("unexpected due messages %+v", msgs)
+	}
+
+	if err := storage.RescheduleMessage(ctx, msgs[0].ID, 1, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.RemoveMessage(ctx, msgs[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, _ := storage.GetDueMessages(ctx, now, 10); len(msgs) != 0 {
+		t.Errorf("expected no due messages, got %+v", msgs)
+	}
+}
+
+func TestDispatchDefersThrottledChats(t *testing.T) {
+	ctx := context.Background()
+	storage := CreateMemoryStorage()
+	now := time.Now().UTC()
+	for _, chatId := range []int64{-100, -100, -100, 1} {
+		if err := storage.EnqueueMessage(ctx, OutboxMessage{ChatId: chatId, NextAttempt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs, err := storage.GetDueMessages(ctx, now, outboxBatchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := &Outbox{storage: storage, busy: map[int64]string{}, chatNext: map[int64]time.Time{}}
+	jobs := make(chan OutboxMessage, len(msgs))
+	o.dispatch(ctx, msgs, jobs, now)
+	close(jobs)
+
+	sent := []OutboxMessage{}
+	for msg := range jobs {
+		sent = append(sent, msg)
+	}
+	if len(sent) != 2 || sent[0].ID != msgs[0].ID || sent[1].ChatId != 1 {
+		t.Fatalf("expected the first group message and the private one to be sent, got %+v", sent)
+	}
+
+	// messages being sent are still in the queue, so they are skipped without rescheduling
+	o.dispatch(ctx, msgs[:1], make(chan OutboxMessage, 1), now)
+	if due, _ := storage.GetDueMessages(ctx, now, outboxBatchSize); len(due) != 2 {
+		t.Errorf("expected only messages being sent to be due, got %+v", due)
+	}
+
+	due, _ := storage.GetDueMessages(ctx, now.Add(groupSendInterval), outboxBatchSize)
+	if len(due) != 3 || due[1].ID != msgs[1].ID {
+		t.Errorf("expected the second group message on the next slot, got %+v", due)
+	}
+	due, _ = storage.GetDueMessages(ctx, now.Add(2*groupSendInterval), outboxBatchSize)
+	if len(due) != 4 {
+		t.Errorf("expected the third group message a slot later, got %+v", due)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces out calls shared by several goroutines by interval plus random jitter,
+// so requests to a host don't come in bursts or at exact intervals
+type rateLimiter struct {
+	lock     sync.Mutex
+	interval time.Duration
+	jitter   time.Duration
+	next     time.Time
+}
+
+func createRateLimiter(interval time.Duration, jitter time.Duration) *rateLimiter {
+	return &rateLimiter{interval: interval, jitter: jitter}
+}
+
+// Wait blocks until the caller's turn comes, it returns error when ctx is done first
+func (rl *rateLimiter) Wait(ctx context.Context) error {
+	rl.lock.Lock()
+	now := time.Now()
+	if rl.next.Before(now) {
+		rl.next = now
+	}
+	at := rl.next
+	rl.next = rl.next.Add(rl.interval)
+	if rl.jitter > 0 {
+		rl.next = rl.next.Add(time.Duration(rand.Int63n(int64(rl.jitter))))
+	}
+	rl.lock.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Until(at)):
+		return nil
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterSpacesOutCalls(t *testing.T) {
+	rl := createRateLimiter(20*time.Millisecond, 10*time.Millisecond)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := rl.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("3 calls took only %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rl = createRateLimiter(time.Hour, 0)
+	rl.Wait(ctx)
+	if err := rl.Wait(ctx); err == nil {
+		t.Error("expected wait to be cancelled")
+	}
+}
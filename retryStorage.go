@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	retryAttempts  = 4
+	retryBaseDelay = 250 * time.Millisecond
+	retryMaxDelay  = 4 * time.Second
+	// unhealthyAfter is the amount of operations in a row which failed even after retries
+	unhealthyAfter = 3
+)
+
+// RetryStorage retries reads and idempotent writes of the wrapped storage failed with transient errors
+// and reports storage as unhealthy once operations keep failing, Ping isn't retried to report reachability as is
+type RetryStorage struct {
+	Storage
+	baseDelay     time.Duration
+	lock          sync.Mutex
+	failuresInRow int
+	failingSince  time.Time
+	lastErr       error
+}
+
+func CreateRetryStorage(storage Storage) *RetryStorage {
+	return &RetryStorage{Storage: storage, baseDelay: retryBaseDelay}
+}
+
+// isTransientError tells if operation may succeed when repeated, like network errors or timeouts
+func isTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) || errors.Is(err, bolt.ErrTimeout) {
+		return true
+	}
+
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// Health returns nil while storage works, otherwise the error it keeps failing with
+func (rs *RetryStorage) Health() error {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	if rs.failuresInRow < unhealthyAfter {
+		return nil
+	}
+	return fmt.Errorf("storage is failing since %s: %w", rs.failingSince.Format(time.RFC1123Z), rs.lastErr)
+}
+
+func (rs *RetryStorage) report(err error) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	if !isTransientError(err) {
+		if rs.failuresInRow >= unhealthyAfter {
+			fmt.Println("Storage is healthy again")
+		}
+		rs.failuresInRow = 0
+		return
+	}
+
+	if rs.failuresInRow == 0 {
+		rs.failingSince = time.Now().UTC()
+	}
+	rs.failuresInRow++
+	rs.lastErr = err
+	if rs.failuresInRow == unhealthyAfter {
+		fmt.Printf("Storage is unhealthy: %v\n", err)
+	}
+}
+
+func retry[T any](ctx context.Context, rs *RetryStorage, op func() (T, error)) (T, error) {
+	delay := rs.baseDelay
+	res, err := op()
+	for attempt := 1; attempt < retryAttempts && isTransientError(err); attempt++ {
+		fmt.Printf("Storage operation failed, retrying in %v: %v\n", delay, err)
+		select {
+		case <-ctx.Done():
+			rs.report(err)
+			return res, err
+		case <-time.After(delay + time.Duration(rand.Int63n(int64(delay/2)))):
+		}
+
+		delay *= 2
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+		res, err = op()
+	}
+
+	rs.report(err)
+	return res, err
+}
+
+// once runs operations which result depends on the state they change, like inserts telling if the record is new,
+// repeating them after a timeout could see their own write and give a wrong answer
+func once[T any](rs *RetryStorage, op func() (T, error)) (T, error) {
+	res, err := op()
+	rs.report(err)
+	return res, err
+}
+
+func retryErr(ctx context.Context, rs *RetryStorage, op func() error) error {
+	_, err := retry(ctx, rs, func() (struct{}, error) {
+		return struct{}{}, op()
+	})
+	return err
+}
+
+func (rs *RetryStorage) SetLastTimeCheckedUTC(ctx context.Context, category DouCategory, exp string, checked time.Time) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.SetLastTimeCheckedUTC(ctx, category, exp, checked)
+	})
+}
+
+func (rs *RetryStorage) GetLastTimeCheckedUTC(ctx context.Context, category DouCategory, exp string) (time.Time, error) {
+	return retry(ctx, rs, func() (time.Time, error) {
+		return rs.Storage.GetLastTimeCheckedUTC(ctx, category, exp)
+	})
+}
+
+func (rs *RetryStorage) SetFeedValidators(ctx context.Context, category DouCategory, exp string, validators FeedValidators) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.SetFeedValidators(ctx, category, exp, validators)
+	})
+}
+
+func (rs *RetryStorage) GetFeedValidators(ctx context.Context, category DouCategory, exp string) (FeedValidators, error) {
+	return retry(ctx, rs, func() (FeedValidators, error) {
+		return rs.Storage.GetFeedValidators(ctx, category, exp)
+	})
+}
+
+func (rs *RetryStorage) SubscribeUser(ctx context.Context, sub SubscriptionCategory, userId int, chatId int64, userName string) (bool, error) {
+	return once(rs, func() (bool, error) {
+		return rs.Storage.SubscribeUser(ctx, sub, userId, chatId, userName)
+	})
+}
+
+func (rs *RetryStorage) SubscribeMany(ctx context.Context, subs []SubscriptionCategory, userId int, chatId int64, userName string) (int, error) {
+	return once(rs, func() (int, error) {
+		return rs.Storage.SubscribeMany(ctx, subs, userId, chatId, userName)
+	})
+}
+
+func (rs *RetryStorage) UnsubscribeUser(ctx context.Context, subscriptionId string, chatId int64) (bool, error) {
+	return once(rs, func() (bool, error) {
+		return rs.Storage.UnsubscribeUser(ctx, subscriptionId, chatId)
+	})
+}
+
+func (rs *RetryStorage) UpdateSubscription(ctx context.Context, chatId int64, sub SubscriptionCategory) (bool, error) {
+	return once(rs, func() (bool, error) {
+		return rs.Storage.UpdateSubscription(ctx, chatId, sub)
+	})
+}
+
+func (rs *RetryStorage) GetSubscriptionInfo(ctx context.Context, chatId int64) (SubscriptionInfo, error) {
+	return retry(ctx, rs, func() (SubscriptionInfo, error) {
+		return rs.Storage.GetSubscriptionInfo(ctx, chatId)
+	})
+}
+
+func (rs *RetryStorage) GetAllSubscribers(ctx context.Context, categoryName string, categoryId string, exp string) ([]SubscriptionInfo, error) {
+	return retry(ctx, rs, func() ([]SubscriptionInfo, error) {
+		return rs.Storage.GetAllSubscribers(ctx, categoryName, categoryId, exp)
+	})
+}
+
+func (rs *RetryStorage) MarkVacancySent(ctx context.Context, chatId int64, vacancyUrl string) (bool, error) {
+	return once(rs, func() (bool, error) {
+		return rs.Storage.MarkVacancySent(ctx, chatId, vacancyUrl)
+	})
+}
+
+func (rs *RetryStorage) UnmarkVacancySent(ctx context.Context, chatId int64, vacancyUrl string) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.UnmarkVacancySent(ctx, chatId, vacancyUrl)
+	})
+}
+
+func (rs *RetryStorage) RemoveSentVacanciesBefore(ctx context.Context, before time.Time) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.RemoveSentVacanciesBefore(ctx, before)
+	})
+}
+
+func (rs *RetryStorage) SetDeliveryMode(ctx context.Context, chatId int64, mode string, digestHour int) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.SetDeliveryMode(ctx, chatId, mode, digestHour)
+	})
+}
+
+func (rs *RetryStorage) SetLastDigestDate(ctx context.Context, chatId int64, date time.Time) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.SetLastDigestDate(ctx, chatId, date)
+	})
+}
+
+func (rs *RetryStorage) SetQuietHours(ctx context.Context, chatId int64, timezone string, quietFrom int, quietTo int) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.SetQuietHours(ctx, chatId, timezone, quietFrom, quietTo)
+	})
+}
+
+func (rs *RetryStorage) SaveCategories(ctx context.Context, categories []CategoryRecord) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.SaveCategories(ctx, categories)
+	})
+}
+
+func (rs *RetryStorage) GetCategories(ctx context.Context) ([]CategoryRecord, error) {
+	return retry(ctx, rs, func() ([]CategoryRecord, error) {
+		return rs.Storage.GetCategories(ctx)
+	})
+}
+
+func (rs *RetryStorage) RenameCategory(ctx context.Context, categoryId string, name string) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.RenameCategory(ctx, categoryId, name)
+	})
+}
+
+func (rs *RetryStorage) SetChannelLink(ctx context.Context, link ChannelLink) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.SetChannelLink(ctx, link)
+	})
+}
+
+func (rs *RetryStorage) GetChannelLink(ctx context.Context, chatId int64) (ChannelLink, error) {
+	return retry(ctx, rs, func() (ChannelLink, error) {
+		return rs.Storage.GetChannelLink(ctx, chatId)
+	})
+}
+
+func (rs *RetryStorage) RemoveChannelLink(ctx context.Context, chatId int64) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.RemoveChannelLink(ctx, chatId)
+	})
+}
+
+func (rs *RetryStorage) DeactivateChat(ctx context.Context, chatId int64, reason string, date time.Time) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.DeactivateChat(ctx, chatId, reason, date)
+	})
+}
+
+func (rs *RetryStorage) ReactivateChat(ctx context.Context, chatId int64) (bool, error) {
+	return once(rs, func() (bool, error) {
+		return rs.Storage.ReactivateChat(ctx, chatId)
+	})
+}
+
+func (rs *RetryStorage) AddPendingVacancy(ctx context.Context, chatId int64, vacancy VacancyRecord) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.AddPendingVacancy(ctx, chatId, vacancy)
+	})
+}
+
+func (rs *RetryStorage) GetPendingVacancies(ctx context.Context, chatId int64) ([]VacancyRecord, error) {
+	return retry(ctx, rs, func() ([]VacancyRecord, error) {
+		return rs.Storage.GetPendingVacancies(ctx, chatId)
+	})
+}
+
+func (rs *RetryStorage) RemovePendingVacancies(ctx context.Context, chatId int64, urls []string) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.RemovePendingVacancies(ctx, chatId, urls)
+	})
+}
+
+func (rs *RetryStorage) GetPendingChats(ctx context.Context) (map[int64]time.Time, error) {
+	return retry(ctx, rs, func() (map[int64]time.Time, error) {
+		return rs.Storage.GetPendingChats(ctx)
+	})
+}
+
+// EnqueueMessage generates message id before the first attempt, so a retried write doesn't queue the message twice
+func (rs *RetryStorage) EnqueueMessage(ctx context.Context, msg OutboxMessage) error {
+	if msg.ID == "" {
+		msg.ID = newOutboxId()
+	}
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.EnqueueMessage(ctx, msg)
+	})
+}
+
+func (rs *RetryStorage) GetDueMessages(ctx context.Context, now time.Time, limit int) ([]OutboxMessage, error) {
+	return retry(ctx, rs, func() ([]OutboxMessage, error) {
+		return rs.Storage.GetDueMessages(ctx, now, limit)
+	})
+}
+
+func (rs *RetryStorage) RescheduleMessage(ctx context.Context, id string, attempts int, nextAttempt time.Time) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.RescheduleMessage(ctx, id, attempts, nextAttempt)
+	})
+}
+
+func (rs *RetryStorage) RemoveMessage(ctx context.Context, id string) error {
+	return retryErr(ctx, rs, func() error {
+		return rs.Storage.RemoveMessage(ctx, id)
+	})
+}
+
+func (rs *RetryStorage) CountMessages(ctx context.Context) (int, error) {
+	return retry(ctx, rs, func() (int, error) {
+		return rs.Storage.CountMessages(ctx)
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "i/o timeout" }
+func (timeoutError) Timeout() bool { return true }
+
+// flakyStorage fails reading subscriptions the given amount of times
+type flakyStorage struct {
+	*MemoryStorage
+	failures int
+	calls    int
+	err      error
+}
+
+func (fs *flakyStorage) GetSubscriptionInfo(ctx context.Context, chatId int64) (SubscriptionInfo, error) {
+	fs.calls++
+	if fs.calls <= fs.failures {
+		return SubscriptionInfo{}, fs.err
+	}
+	return fs.MemoryStorage.GetSubscriptionInfo(ctx, chatId)
+}
+
+// writes are done before failing, like when a timeout hits while waiting for the answer
+func (fs *flakyStorage) MarkVacancySent(ctx context.Context, chatId int64, vacancyUrl string) (bool, error) {
+	fs.calls++
+	isNew, err := fs.MemoryStorage.MarkVacancySent(ctx, chatId, vacancyUrl)
+	if fs.calls <= fs.failures {
+		return false, fs.err
+	}
+	return isNew, err
+}
+
+func (fs *flakyStorage) EnqueueMessage(ctx context.Context, msg OutboxMessage) error {
+	fs.calls++
+	err := fs.MemoryStorage.EnqueueMessage(ctx, msg)
+	if fs.calls <= fs.failures {
+		return fs.err
+	}
+	return err
+}
+
+func createFlakyRetryStorage(failures int, err error) (*RetryStorage, *flakyStorage) {
+	flaky := &flakyStorage{MemoryStorage: CreateMemoryStorage(), failures: failures, err: err}
+	rs := CreateRetryStorage(flaky)
+	rs.baseDelay = time.Millisecond
+	return rs, flaky
+}
+
+func TestRetryStorageRetriesTransientErrors(t *testing.T) {
+	ctx := context.Background()
+	rs, flaky := createFlakyRetryStorage(retryAttempts-1, timeoutError{})
+	if _, err := rs.SubscribeUser(ctx, SubscriptionCategory{IDCategory: "Golang"}, 1, 1, "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	subInfo, err := rs.GetSubscriptionInfo(ctx, 1)
+	if err != nil {
+		t.Fatalf("transient errors weren't retried: %v", err)
+	}
+	if len(subInfo.Subscriptions) != 1 || flaky.calls != retryAttempts {
+		t.Errorf("unexpected result %+v after %d calls", subInfo, flaky.calls)
+	}
+	if err := rs.Health(); err != nil {
+		t.Errorf("storage is reported unhealthy: %v", err)
+	}
+}
+
+func TestRetryStorageDoesNotRetryOtherErrors(t *testing.T) {
+	errNotFound := errors.New("not found")
+	rs, flaky := createFlakyRetryStorage(1, errNotFound)
+	if _, err := rs.GetSubscriptionInfo(context.Background(), 1); !errors.Is(err, errNotFound) || flaky.calls != 1 {
+		t.Errorf("expected single failed call, got %v after %d calls", err, flaky.calls)
+	}
+}
+
+func TestRetryStorageHealth(t *testing.T) {
+	ctx := context.Background()
+	rs, _ := createFlakyRetryStorage(unhealthyAfter*retryAttempts, timeoutError{})
+	rs.SubscribeUser(ctx, SubscriptionCategory{IDCategory: "Golang"}, 1, 1, "user")
+
+	for i := 0; i < unhealthyAfter; i++ {
+		if err := rs.Health(); err != nil {
+			t.Fatalf("storage is unhealthy after %d failures: %v", i, err)
+		}
+		rs.GetSubscriptionInfo(ctx, 1)
+	}
+	if rs.Health() == nil {
+		t.Fatal("storage is healthy after persistent failures")
+	}
+
+	if _, err := rs.GetSubscriptionInfo(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Health(); err != nil {
+		t.Errorf("storage didn't recover: %v", err)
+	}
+}
+
+func TestRetryStorageDoesNotRepeatMarkingVacancySent(t *testing.T) {
+	rs, flaky := createFlakyRetryStorage(1, timeoutError{})
+	if _, err := rs.MarkVacancySent(context.Background(), 1, "https://jobs.dou.ua/1"); err == nil || flaky.calls != 1 {
+		t.Errorf("expected single failed call, got %v after %d calls", err, flaky.calls)
+	}
+}
+
+func TestRetryStorageEnqueuesMessageOnce(t *testing.T) {
+	ctx := context.Background()
+	rs, flaky := createFlakyRetryStorage(1, timeoutError{})
+	if err := rs.EnqueueMessage(ctx, OutboxMessage{ChatId: 1, Text: "vacancy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := flaky.CountMessages(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || flaky.calls != 2 {
+		t.Errorf("expected one message after retry, got %d after %d calls", count, flaky.calls)
+	}
+}
@@ -1,16 +1,80 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Storage keeps subscriptions per chat, so private chats, groups and channels have their own ones,
+// userId only tells who created the subscriptions
 type Storage interface {
-	SetLastTimeCheckedUTC(category DouCategory, exp string) error
-	GetLastTimeCheckedUTC(category DouCategory, exp string) time.Time
-	SubscribeUser(category DouCategory, exp string, userId int, chatId int64, userName string) (bool, error)
-	UnsubscribeUser(categoryId string, userId int) (bool, error)
-	GetSubscriptionInfo(userId int) (SubscriptionInfo, error)
-	GetAllSubscribers(categoryName string, categoryId string, exp string) ([]SubscriptionInfo, error)
+	// SetLastTimeCheckedUTC stores publication date of the newest vacancy handed off for the category
+	SetLastTimeCheckedUTC(ctx context.Context, category DouCategory, exp string, checked time.Time) error
+	// GetLastTimeCheckedUTC returns zero time for categories which weren't checked yet
+	GetLastTimeCheckedUTC(ctx context.Context, category DouCategory, exp string) (time.Time, error)
+	SetFeedValidators(ctx context.Context, category DouCategory, exp string, validators FeedValidators) error
+	// GetFeedValidators returns empty validators for feeds which weren't visited yet
+	GetFeedValidators(ctx context.Context, category DouCategory, exp string) (FeedValidators, error)
+	SubscribeUser(ctx context.Context, sub SubscriptionCategory, userId int, chatId int64, userName string) (bool, error)
+	// SubscribeMany adds all not yet subscribed subscriptions in one write, returns the amount of added ones
+	SubscribeMany(ctx context.Context, subs []SubscriptionCategory, userId int, chatId int64, userName string) (int, error)
+	UnsubscribeUser(ctx context.Context, subscriptionId string, chatId int64) (bool, error)
+	// UpdateSubscription replaces chat's subscription with the same id, fails with ErrSubscriptionExists
+	// if other subscription already has the same category, experience and filters
+	UpdateSubscription(ctx context.Context, chatId int64, sub SubscriptionCategory) (bool, error)
+	GetSubscriptionInfo(ctx context.Context, chatId int64) (SubscriptionInfo, error)
+	// GetAllSubscribers matches subscriptions by category id and experience, so renamed categories keep their subscribers.
+	// Inactive chats are skipped
+	GetAllSubscribers(ctx context.Context, categoryName string, categoryId string, exp string) ([]SubscriptionInfo, error)
+	// MarkVacancySent remembers vacancy as delivered to chat, returns false if it was already delivered
+	MarkVacancySent(ctx context.Context, chatId int64, vacancyUrl string) (bool, error)
+	// UnmarkVacancySent forgets the vacancy was delivered when handing it off to the chat failed
+	UnmarkVacancySent(ctx context.Context, chatId int64, vacancyUrl string) error
+	RemoveSentVacanciesBefore(ctx context.Context, before time.Time) error
+	SetDeliveryMode(ctx context.Context, chatId int64, mode string, digestHour int) error
+	SetLastDigestDate(ctx context.Context, chatId int64, date time.Time) error
+	SetQuietHours(ctx context.Context, chatId int64, timezone string, quietFrom int, quietTo int) error
+	// AddPendingVacancy queues vacancy for the next digest, vacancy already queued for the chat is skipped
+	AddPendingVacancy(ctx context.Context, chatId int64, vacancy VacancyRecord) error
+	// GetPendingVacancies returns vacancies queued for the chat in the order they were queued
+	GetPendingVacancies(ctx context.Context, chatId int64) ([]VacancyRecord, error)
+	// RemovePendingVacancies removes vacancies of the chat once they are queued to be sent
+	RemovePendingVacancies(ctx context.Context, chatId int64, urls []string) error
+	// GetPendingChats returns chats with queued vacancies and the time the oldest of them was queued
+	GetPendingChats(ctx context.Context) (map[int64]time.Time, error)
+	// SaveCategories replaces the stored list of DOU categories
+	SaveCategories(ctx context.Context, categories []CategoryRecord) error
+	// GetCategories returns stored categories in the order they were saved
+	GetCategories(ctx context.Context) ([]CategoryRecord, error)
+	// RenameCategory updates name of the category in all subscriptions to it
+	RenameCategory(ctx context.Context, categoryId string, name string) error
+	// SetChannelLink remembers channel which subscriptions are managed from a private chat
+	SetChannelLink(ctx context.Context, link ChannelLink) error
+	// GetChannelLink returns empty link when no channel is linked to the chat
+	GetChannelLink(ctx context.Context, chatId int64) (ChannelLink, error)
+	RemoveChannelLink(ctx context.Context, chatId int64) error
+	// DeactivateChat stops deliveries to chat which blocked the bot or was deleted
+	DeactivateChat(ctx context.Context, chatId int64, reason string, date time.Time) error
+	// ReactivateChat resumes deliveries, it returns false when chat wasn't inactive
+	ReactivateChat(ctx context.Context, chatId int64) (bool, error)
+	// EnqueueMessage stores message to be sent by outbox workers, id is generated when message doesn't have one,
+	// enqueueing a message with the same id again keeps a single copy
+	EnqueueMessage(ctx context.Context, msg OutboxMessage) error
+	// GetDueMessages returns up to limit messages which next attempt time has come, oldest first
+	GetDueMessages(ctx context.Context, now time.Time, limit int) ([]OutboxMessage, error)
+	RescheduleMessage(ctx context.Context, id string, attempts int, nextAttempt time.Time) error
+	// RemoveMessage removes message from the outbox once it is delivered or can't be delivered at all
+	RemoveMessage(ctx context.Context, id string) error
+	CountMessages(ctx context.Context) (int, error)
+	// Ping checks that storage can be reached
+	Ping(ctx context.Context) error
+	Close(ctx context.Context) error
 }
 
 type CategoryInfo struct {
@@ -18,17 +82,169 @@ type CategoryInfo struct {
 	NameCategory    string `bson:"nameCategory,omitempty"`
 	Experience      string `bson:"experience,omitempty"`
 	LastTimeChecked string `bson:"lastTimeChecked,omitempty"`
+	FeedValidators  `bson:",inline"`
+}
+
+// FeedValidators tell if feed changed since the last visit, content hash is used when DOU doesn't send the headers
+type FeedValidators struct {
+	ETag         string `bson:"etag,omitempty"`
+	LastModified string `bson:"lastModified,omitempty"`
+	ContentHash  string `bson:"contentHash,omitempty"`
+}
+
+// CategoryRecord is a DOU category as it is stored, its feed url is built from the id
+type CategoryRecord struct {
+	ID    string `bson:"_id,omitempty"`
+	Name  string `bson:"name,omitempty"`
+	Order int    `bson:"order"`
+}
+
+// ChannelLink is a channel linked with /channel, commands sent to the private chat manage its subscriptions
+type ChannelLink struct {
+	ChatId      int64  `bson:"_id"`
+	ChannelId   int64  `bson:"channelId,omitempty"`
+	ChannelName string `bson:"channelName,omitempty"`
 }
 
+// ErrSubscriptionExists is returned when subscription with the same category, experience and filters is already present
+var ErrSubscriptionExists = errors.New("Subscription with the same category, experience and filters already exists")
+
 type SubscriptionCategory struct {
-	IDCategory   string `bson:"idCategory,omitempty"`
-	NameCategory string `bson:"nameCategory,omitempty"`
-	Experience   string `bson:"experience,omitempty"`
+	ID              string   `bson:"id,omitempty"`
+	IDCategory      string   `bson:"idCategory,omitempty"`
+	NameCategory    string   `bson:"nameCategory,omitempty"`
+	Experience      string   `bson:"experience,omitempty"`
+	IncludeKeywords []string `bson:"includeKeywords,omitempty"`
+	ExcludeKeywords []string `bson:"excludeKeywords,omitempty"`
+	MinSalary       int      `bson:"minSalary,omitempty"`
+	HideNoSalary    bool     `bson:"hideNoSalary,omitempty"`
+	Cities          []string `bson:"cities,omitempty"`
+	Remote          bool     `bson:"remote,omitempty"`
+	Relocation      bool     `bson:"relocation,omitempty"`
 }
+
+func CreateSubscriptionCategory(category DouCategory, exp string) SubscriptionCategory {
+	return SubscriptionCategory{ID: newSubscriptionId(), IDCategory: IdToDBId(category.id), NameCategory: category.name, Experience: IdToDBId(exp)}
+}
+
+// newOutboxId generates message id, object ids start with creation time, so sorting by them keeps the order messages were queued
+func newOutboxId() string {
+	return primitive.NewObjectID().Hex()
+}
+
+// newSubscriptionId generates random id which stays the same while subscription is edited,
+// it is short enough to fit into callback data of inline buttons
+func newSubscriptionId() string {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+type SentVacancy struct {
+	ChatId   int64     `bson:"chatId,omitempty"`
+	Url      string    `bson:"url,omitempty"`
+	SentDate time.Time `bson:"sentDate,omitempty"`
+}
+
+// VacancyRecord is a stored copy of DouVacancy
+type VacancyRecord struct {
+	Url            string   `bson:"url,omitempty"`
+	Name           string   `bson:"name,omitempty"`
+	Position       string   `bson:"position,omitempty"`
+	Company        string   `bson:"company,omitempty"`
+	SalaryMin      int      `bson:"salaryMin,omitempty"`
+	SalaryMax      int      `bson:"salaryMax,omitempty"`
+	SalaryCurrency string   `bson:"salaryCurrency,omitempty"`
+	Cities         []string `bson:"cities,omitempty"`
+	Remote         bool     `bson:"remote,omitempty"`
+	Relocation     bool     `bson:"relocation,omitempty"`
+	Snippet        string   `bson:"snippet,omitempty"`
+	Experience     string   `bson:"experience,omitempty"`
+	CategoryId     string   `bson:"categoryId,omitempty"`
+	CategoryName   string   `bson:"categoryName,omitempty"`
+}
+
+type PendingVacancy struct {
+	ChatId  int64         `bson:"chatId,omitempty"`
+	Vacancy VacancyRecord `bson:"vacancy,omitempty"`
+	AddDate time.Time     `bson:"addDate,omitempty"`
+}
+
+// OutboxMessage is a message waiting to be sent to a chat
+type OutboxMessage struct {
+	ID             string    `bson:"_id,omitempty"`
+	ChatId         int64     `bson:"chatId,omitempty"`
+	Text           string    `bson:"text,omitempty"`
+	DisablePreview bool      `bson:"disablePreview,omitempty"`
+	Attempts       int       `bson:"attempts,omitempty"`
+	NextAttempt    time.Time `bson:"nextAttempt,omitempty"`
+	CreateDate     time.Time `bson:"createDate,omitempty"`
+}
+
 type SubscriptionInfo struct {
-	UserId        int                    `bson:"userId,omitempty"`
-	ChatId        int64                  `bson:"chatId,omitempty"`
-	UserName      string                 `bson:"userName,omitempty"`
-	CreateDate    string                 `bson:"createDate,omitempty"`
-	Subscriptions []SubscriptionCategory `bson:"subscriptions,omitempty"`
+	UserId         int                    `bson:"userId,omitempty"`
+	ChatId         int64                  `bson:"chatId,omitempty"`
+	UserName       string                 `bson:"userName,omitempty"`
+	CreateDate     string                 `bson:"createDate,omitempty"`
+	DeliveryMode   string                 `bson:"deliveryMode,omitempty"`
+	DigestHour     int                    `bson:"digestHour,omitempty"`
+	LastDigestDate string                 `bson:"lastDigestDate,omitempty"`
+	Timezone       string                 `bson:"timezone,omitempty"`
+	QuietFrom      int                    `bson:"quietFrom,omitempty"`
+	QuietTo        int                    `bson:"quietTo,omitempty"`
+	Inactive       bool                   `bson:"inactive,omitempty"`
+	InactiveReason string                 `bson:"inactiveReason,omitempty"`
+	InactiveDate   string                 `bson:"inactiveDate,omitempty"`
+	Subscriptions  []SubscriptionCategory `bson:"subscriptions,omitempty"`
+}
+
+func CreateVacancyRecord(vacancy DouVacancy) VacancyRecord {
+	return VacancyRecord{
+		Url:            vacancy.url,
+		Name:           vacancy.name,
+		Position:       vacancy.position,
+		Company:        vacancy.company,
+		SalaryMin:      vacancy.salary.min,
+		SalaryMax:      vacancy.salary.max,
+		SalaryCurrency: vacancy.salary.currency,
+		Cities:         vacancy.cities,
+		Remote:         vacancy.remote,
+		Relocation:     vacancy.relocation,
+		Snippet:        vacancy.snippet,
+		Experience:     vacancy.experience,
+		CategoryId:     vacancy.categoryId,
+		CategoryName:   vacancy.categoryName,
+	}
+}
+
+func (vr VacancyRecord) ToVacancy() DouVacancy {
+	return DouVacancy{
+		url:          vr.Url,
+		name:         vr.Name,
+		position:     vr.Position,
+		company:      vr.Company,
+		salary:       DouSalary{min: vr.SalaryMin, max: vr.SalaryMax, currency: vr.SalaryCurrency},
+		cities:       vr.Cities,
+		remote:       vr.Remote,
+		relocation:   vr.Relocation,
+		snippet:      vr.Snippet,
+		experience:   vr.Experience,
+		categoryId:   vr.CategoryId,
+		categoryName: vr.CategoryName,
+	}
+}
+
+// CreateStorage picks the storage backend by name, mongo is used when kind is empty
+func CreateStorage(ctx context.Context, kind string) (Storage, error) {
+	switch kind {
+	case "", "mongo":
+		return CreateMongoStorage(ctx)
+	case "memory":
+		return CreateMemoryStorage(), nil
+	case "bolt":
+		return CreateBoltStorage()
+	}
+	return nil, fmt.Errorf("Unknown storage `%s`", kind)
 }
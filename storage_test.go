@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionsWithDifferentExperience(t *testing.T) {
+	ctx := context.Background()
+	storage := CreateMemoryStorage()
+	java := DouCategory{id: "Java", name: "Java"}
+	subs := []SubscriptionCategory{CreateSubscriptionCategory(java, "1-3"), CreateSubscriptionCategory(java, "3-5")}
+
+	added, err := storage.SubscribeMany(ctx, subs, 1, 1, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 {
+		t.Fatalf("expected 2 subscriptions to be added, got %d", added)
+	}
+
+	subInfo, err := storage.GetSubscriptionInfo(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	junior, middle := subInfo.Subscriptions[0], subInfo.Subscriptions[1]
+	if junior.ID == "" || junior.ID == middle.ID {
+		t.Fatalf("subscriptions don't have unique ids: %+v", subInfo.Subscriptions)
+	}
+
+	junior.Experience = middle.Experience
+	if _, err := storage.UpdateSubscription(ctx, 1, junior); !errors.Is(err, ErrSubscriptionExists) {
+		t.Errorf("expected duplicate subscription error, got %v", err)
+	}
+
+	junior.Experience = "5plus"
+	if ok, err := storage.UpdateSubscription(ctx, 1, junior); !ok || err != nil {
+		t.Errorf("subscription wasn't updated: %v", err)
+	}
+
+	if ok, err := storage.UnsubscribeUser(ctx, middle.ID, 1); !ok || err != nil {
+		t.Fatalf("subscription wasn't removed: %v", err)
+	}
+
+	subInfo, err = storage.GetSubscriptionInfo(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subInfo.Subscriptions) != 1 || subInfo.Subscriptions[0].ID != junior.ID || subInfo.Subscriptions[0].Experience != "5plus" {
+		t.Errorf("unexpected subscriptions %+v", subInfo.Subscriptions)
+	}
+}
+
+func TestInactiveChatsAreSkipped(t *testing.T) {
+	ctx := context.Background()
+	storage := CreateMemoryStorage()
+	java := DouCategory{id: "Java", name: "Java"}
+	if _, err := storage.SubscribeUser(ctx, CreateSubscriptionCategory(java, "1-3"), 1, 1, "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.DeactivateChat(ctx, 1, "bot was blocked by the user", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if subs, _ := storage.GetAllSubscribers(ctx, "Java", "Java", "1-3"); len(subs) != 0 {
+		t.Errorf("inactive chat was returned: %+v", subs)
+	}
+
+	if ok, err := storage.ReactivateChat(ctx, 1); !ok || err != nil {
+		t.Fatalf("chat wasn't reactivated: %v", err)
+	}
+	if ok, _ := storage.ReactivateChat(ctx, 1); ok {
+		t.Error("active chat was reactivated again")
+	}
+	if subs, _ := storage.GetAllSubscribers(ctx, "Java", "Java", "1-3"); len(subs) != 1 {
+		t.Errorf("reactivated chat wasn't returned: %+v", subs)
+	}
+}
+
+func TestSubscribersAreFoundAfterCategoryRename(t *testing.T) {
+	ctx := context.Background()
+	for name, storage := range map[string]Storage{"memory": CreateMemoryStorage(), "bolt": createTestBoltStorage(t)} {
+		golang := DouCategory{id: "Golang", name: "Golang"}
+		if _, err := storage.SubscribeUser(ctx, CreateSubscriptionCategory(golang, "1-3"), 1, 1, "user"); err != nil {
+			t.Fatal(err)
+		}
+
+		if subs, _ := storage.GetAllSubscribers(ctx, "Go", "Golang", "1-3"); len(subs) != 1 {
+			t.Errorf("%s: subscriber of renamed category wasn't returned: %+v", name, subs)
+		}
+	}
+}
+
+func TestChannelLinkOutlivesSessions(t *testing.T) {
+	ctx := context.Background()
+	for name, storage := range map[string]Storage{"memory": CreateMemoryStorage(), "bolt": createTestBoltStorage(t)} {
+		link, err := storage.GetChannelLink(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if link.ChannelId != 0 {
+			t.Errorf("%s: unexpected link %+v", name, link)
+		}
+
+		expected := ChannelLink{ChatId: 1, ChannelId: -100, ChannelName: "Jobs"}
+		if err := storage.SetChannelLink(ctx, expected); err != nil {
+			t.Fatal(err)
+		}
+		if link, err := storage.GetChannelLink(ctx, 1); err != nil || link != expected {
+			t.Errorf("%s: expected %+v, got %+v, %v", name, expected, link, err)
+		}
+
+		if err := storage.RemoveChannelLink(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		if link, err := storage.GetChannelLink(ctx, 1); err != nil || link.ChannelId != 0 {
+			t.Errorf("%s: link wasn't removed: %+v, %v", name, link, err)
+		}
+	}
+}
+
+func TestPendingVacancyIsQueuedOnce(t *testing.T) {
+	ctx := context.Background()
+	for name, storage := range map[string]Storage{"memory": CreateMemoryStorage(), "bolt": createTestBoltStorage(t)} {
+		for i := 0; i < 2; i++ {
+			if err := storage.AddPendingVacancy(ctx, 1, VacancyRecord{Url: "https://jobs.dou.ua/1"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		vacancies, err := storage.GetPendingVacancies(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(vacancies) != 1 {
+			t.Errorf("%s: expected single vacancy, got %+v", name, vacancies)
+		}
+	}
+}
+
+func TestEnqueueKeepsExistingMessage(t *testing.T) {
+	ctx := context.Background()
+	for name, storage := range map[string]Storage{"memory": CreateMemoryStorage(), "bolt": createTestBoltStorage(t)} {
+		now := time.Now().UTC()
+		msg := OutboxMessage{ID: newOutboxId(), ChatId: 1, Text: "vacancy", NextAttempt: now}
+		if err := storage.EnqueueMessage(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.RescheduleMessage(ctx, msg.ID, 2, now.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.EnqueueMessage(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+
+		msgs, err := storage.GetDueMessages(ctx, now.Add(2*time.Hour), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 1 || msgs[0].Attempts != 2 {
+			t.Errorf("%s: message was overwritten: %+v", name, msgs)
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,21 +18,65 @@ import (
 type stateFn func(*echotron.Update) stateFn
 
 type TelegramBot struct {
+	// ctx is the root context, sessions keep it since echotron doesn't pass one to them
+	ctx       context.Context
 	storage   Storage
 	douWorker *DouWorker
 }
 
+// bot is a session of a chat, targetChatID is the chat which subscriptions it manages,
+// it differs from chatID when a channel is linked
 type bot struct {
-	telegramBot *TelegramBot
-	chatID      int64
-	category    DouCategory
-	state       stateFn
-	messagesIds []int
-	lock        *sync.RWMutex
-	spamData    []int64
+	telegramBot         *TelegramBot
+	chatID              int64
+	targetChatID        int64
+	targetChatName      string
+	subscription        SubscriptionCategory
+	selectedCategories  []DouCategory
+	selectedExperiences []string
+	deliveryMode        string
+	timezone            string
+	state               stateFn
+	messagesIds         []int
+	lock                *sync.RWMutex
+	spamData            []int64
 	echotron.API
 }
 
+const (
+	sentVacancyTTL             = 30 * 24 * time.Hour
+	cleanSentVacanciesInterval = time.Hour
+	// drainTimeout is how long already scraped vacancies are still delivered after shutdown signal
+	drainTimeout = 30 * time.Second
+)
+
+const (
+	showNoSalaryOption = "Так, показувати"
+	hideNoSalaryOption = "Ні, приховати"
+)
+
+// callback data of inline buttons looks like `prefix:value`, prefix tells which step the button belongs to
+const (
+	categoryCallback    = "cat"
+	experienceCallback  = "exp"
+	salaryCallback      = "sal"
+	noSalaryCallback    = "nosal"
+	locationCallback    = "loc"
+	unsubscribeCallback = "unsub"
+	filterCallback      = "filter"
+	modeCallback        = "mode"
+	editCallback        = "edit"
+	fieldCallback       = "field"
+	hourCallback        = "hour"
+	timezoneCallback    = "tz"
+	quietCallback       = "quiet"
+	nextCallback        = "next"
+	confirmCallback     = "confirm"
+)
+
+var salaryOptions = []string{"Будь-яка", "$1000", "$2000", "$3000", "$4000", "$5000"}
+var locationOptions = []string{"Будь-яке", "Київ", "Львів", "Харків", "Дніпро", "Одеса", remoteLocation, relocationLocation}
+
 var token = os.Getenv("TG")
 
 var dsp *echotron.Dispatcher
@@ -43,35 +90,77 @@ func CreateTelegramBot(storage Storage, douWorker *DouWorker) *TelegramBot {
 	return telegramBot
 }
 
-func (tb *TelegramBot) Run() {
-	go pullVacancies(tb)
+// Run serves chats until ctx is cancelled, then waits for vacancies handed off by DouWorker to be queued
+func (tb *TelegramBot) Run(ctx context.Context) {
+	tb.ctx = ctx
+	deliveryCtx, cancelDelivery := drainContext(ctx, drainTimeout)
+	defer cancelDelivery()
+
+	wg := sync.WaitGroup{}
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		pullVacancies(deliveryCtx, tb)
+	}()
+	go func() {
+		defer wg.Done()
+		cleanSentVacancies(ctx, tb)
+	}()
+	go func() {
+		defer wg.Done()
+		sendDigests(ctx, tb)
+	}()
+	go func() {
+		defer wg.Done()
+		CreateOutbox(deliveryCtx, tb.storage).Run(ctx)
+	}()
+
 	dsp = echotron.NewDispatcher(token, func(chatID int64) echotron.Bot {
 		bot := newBot(chatID).(*bot)
 		bot.telegramBot = tb
+		bot.restoreChannelLink()
 		return bot
 	})
-	log.Println(dsp.Poll())
+	// polling can't be stopped, it just dies with the process
+	go func() {
+		log.Println(dsp.Poll())
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down, waiting for in-flight deliveries")
+	wg.Wait()
 }
 
-func newBot(chatID int64) echotron.Bot {
-	bot := &bot{
-		chatID:   chatID,
-		API:      echotron.NewAPI(token),
-		lock:     &sync.RWMutex{},
-		spamData: make([]int64, 3),
-	}
-	bot.state = bot.handleMessage
-	go bot.selfDestruct(time.After(10 * time.Minute))
-	return bot
+// drainContext outlives parent for timeout after parent is done, so work which is already started can finish
+func drainContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-parent.Done():
+		case <-ctx.Done():
+			return
+		}
+
+		select {
+		case <-time.After(timeout):
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	return ctx, cancel
 }
 
-func newBotBroadcast(chatID int64) echotron.Bot {
+func newBot(chatID int64) echotron.Bot {
 	bot := &bot{
-		chatID: chatID,
-		API:    echotron.NewAPI(token),
+		chatID:       chatID,
+		targetChatID: chatID,
+		API:          echotron.NewAPI(token),
+		lock:         &sync.RWMutex{},
+		spamData:     make([]int64, 3),
 	}
 	bot.state = bot.handleMessage
-	go bot.selfDestruct(time.After(10 * time.Second))
+	activeSessions.Inc()
+	go bot.selfDestruct(time.After(10 * time.Minute))
 	return bot
 }
 
@@ -100,10 +189,11 @@ func (b *bot) selfDestruct(timech <-chan time.Time) {
 
 	b.RemoveMessages()
 	dsp.DelSession(b.chatID)
+	activeSessions.Dec()
 }
 
 func (b *bot) Update(update *echotron.Update) {
-	if update == nil || update.Message == nil {
+	if update == nil || (update.Message == nil && update.CallbackQuery == nil) {
 		fmt.Println("destroy session sync issue")
 		return
 	}
@@ -112,6 +202,27 @@ func (b *bot) Update(update *echotron.Update) {
 	b.lock.Lock()
 	defer b.lock.Unlock()
 
+	if isGroupChat(b.chatID) && b.targetChatID == b.chatID && update.Message != nil {
+		b.targetChatName = update.Message.Chat.Title
+	}
+
+	if !b.allowUpdate(update) {
+		return
+	}
+
+	// buttons are pressed way faster than messages are typed, so they aren't checked for spam
+	if update.CallbackQuery != nil {
+		b.AnswerCallbackQuery(update.CallbackQuery.ID, nil)
+		b.state = b.state(update)
+		return
+	}
+
+	// several admins can manage a group at once, so only private chats are checked for spam
+	if isGroupChat(b.chatID) {
+		b.state = b.state(update)
+		return
+	}
+
 	b.SendChatAction(echotron.Typing, b.chatID, nil)
 	if spam := b.CheckForSpam(msgTime); spam {
 		b.SendAutoDeleteMessage("не спамь будь ласка😉", b.chatID, parseModeHTML)
@@ -125,6 +236,9 @@ func (b *bot) Update(update *echotron.Update) {
 }
 
 func (b *bot) AddLastMessageToDeleteList(update *echotron.Update) {
+	if isGroupChat(b.chatID) {
+		return
+	}
 	b.messagesIds = append(b.messagesIds, update.Message.ID)
 }
 
@@ -144,24 +258,78 @@ func (b *bot) handleMessage(update *echotron.Update) stateFn {
 	if r != nil {
 		return r
 	}
+
+	// button of a finished conversation was pressed
+	if update.Message == nil {
+		return b.handleMessage
+	}
+
+	if commandName(update.Message.Text) == "/start" {
+		b.reactivate()
+	}
+
+	// members of a group talk to each other, so help is shown only when asked
+	if isGroupChat(b.chatID) && commandName(update.Message.Text) != "/start" && commandName(update.Message.Text) != "/help" {
+		return b.handleMessage
+	}
+
 	msg := "👇<b>Список команд</b>👇\n\n"
 	msg += "<i>/follow</i> Підписатися на розсилку, та отримувати нові вакансії за категоріями, які ви самі оберете\n\n"
 	msg += "<i>/unfollow</i> Відписатися від розсилки за категоріями\n\n"
-	msg += "<i>/myfollows</i> Ваші поточні підписки"
+	msg += "<i>/myfollows</i> Ваші поточні підписки\n\n"
+	msg += "<i>/edit</i> Змінити досвід, зарплату чи місто для підписки\n\n"
+	msg += "<i>/filter</i> Налаштувати ключові слова для підписки\n\n"
+	msg += "<i>/mode</i> Отримувати вакансії одразу або дайджестом\n\n"
+	msg += "<i>/quiet</i> Налаштувати тихі години, коли я не надсилатиму вакансії\n\n"
+	msg += "<i>/channel</i> Публікувати вакансії в каналі, адміністратором якого ви є"
 	b.SendAutoDeleteMessage(msg, b.chatID, parseModeHTML)
 
 	return b.handleMessage
 }
+
+// reactivate resumes deliveries to the chat which blocked the bot earlier and came back
+func (b *bot) reactivate() {
+	isReactivated, err := b.telegramBot.storage.ReactivateChat(b.telegramBot.ctx, b.chatID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if isReactivated {
+		fmt.Printf("Chat %d is active again\n", b.chatID)
+		b.SendAutoDeleteMessage("👋 З поверненням! Я знову надсилатиму вакансії за вашими підписками", b.chatID, parseModeHTML)
+	}
+}
+
 func (b *bot) handleCommands(update *echotron.Update) stateFn {
-	if update.Message.Text == "/follow" {
+	if update.Message == nil {
+		return nil
+	}
+
+	command := commandName(update.Message.Text)
+	if command == "/follow" {
 		return b.handleSubscribe(update)
 	}
-	if update.Message.Text == "/unfollow" {
+	if command == "/unfollow" {
 		return b.handleUnsubscribe(update)
 	}
-	if update.Message.Text == "/myfollows" {
+	if command == "/myfollows" {
 		return b.handleMySubcriptions(update)
 	}
+	if command == "/edit" {
+		return b.handleEdit(update)
+	}
+	if command == "/filter" {
+		return b.handleFilter(update)
+	}
+	if command == "/mode" {
+		return b.handleMode(update)
+	}
+	if command == "/quiet" {
+		return b.handleQuiet(update)
+	}
+	if command == "/channel" {
+		return b.handleChannel(update)
+	}
 
 	return nil
 }
@@ -174,12 +342,7 @@ func (b *bot) handleMySubcriptions(update *echotron.Update) stateFn {
 
 	subs := []string{}
 	for _, subCat := range subInfo.Subscriptions {
-		for v, filter := range b.telegramBot.douWorker.experienceFilters {
-			if DBIdToId(subCat.Experience) == filter {
-				s := fmt.Sprintf("%s(%s)", subCat.NameCategory, v)
-				subs = append(subs, formatString(s))
-			}
-		}
+		subs = append(subs, formatString(b.formatSubscription(subCat)))
 	}
 
 	b.SendAutoDeleteMessage(fmt.Sprintf("✅ Ви підписані на: <b>%s</b>", strings.Join(subs, ", ")), b.chatID, parseModeHTML)
@@ -187,106 +350,326 @@ func (b *bot) handleMySubcriptions(update *echotron.Update) stateFn {
 }
 
 func (b *bot) handleSubscribe(update *echotron.Update) stateFn {
-	btns := [][]echotron.KeyboardButton{}
-	for id, category := range b.telegramBot.douWorker.categories {
-		if id%3 == 0 {
-			btns = append(btns, []echotron.KeyboardButton{})
+	b.selectedCategories = []DouCategory{}
+	b.selectedExperiences = []string{}
+	b.subscription = SubscriptionCategory{}
+	b.sendCategoriesMenu(update, "")
+
+	return b.handleSubscribeForCategory
+}
+
+func (b *bot) sendCategoriesMenu(update *echotron.Update, warning string) {
+	btns := []echotron.InlineKeyboardButton{}
+	for _, category := range b.telegramBot.douWorker.Categories() {
+		name := category.name
+		if indexOfCategory(b.selectedCategories, category.id) != -1 {
+			name = "✅ " + name
+		}
+		btns = append(btns, callbackButton(name, categoryCallback, category.id))
+	}
+
+	keyboard := append(inlineKeyboard(btns, 3), []echotron.InlineKeyboardButton{callbackButton("Далі ➡️", nextCallback, "")})
+	b.SendMenu(warning+"🎯 Оберіть одну чи кілька категорій, за якими ви бажаете отримувати повідомлення про нові вакансії, щойно вони з'являються на DOU", update, keyboard)
+}
+
+func (b *bot) handleSubscribeForCategory(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
+	}
+
+	if isCallback(update, nextCallback) {
+		if len(b.selectedCategories) == 0 {
+			b.sendCategoriesMenu(update, "🚫 Оберіть хоча б одну категорію\n\n")
+			return b.handleSubscribeForCategory
 		}
-		btns[len(btns)-1] = append(btns[len(btns)-1], echotron.KeyboardButton{Text: category.name})
+
+		b.sendExperienceMenu(update, "")
+		return b.handleCategoryExperience
 	}
-	options := echotron.MessageOptions{
-		ReplyMarkup: echotron.ReplyKeyboardMarkup{
-			Keyboard:        btns,
-			OneTimeKeyboard: true,
-		},
+
+	value, ok := readInput(update, categoryCallback)
+	if !ok {
+		return b.handleSubscribeForCategory
+	}
+
+	category, err := b.findCategory(value)
+	if err != nil {
+		b.sendCategoriesMenu(update, "🚫 Ви обрали не існуючу категорію\n\n")
+		return b.handleSubscribeForCategory
 	}
 
-	b.SendAutoDeleteMessage("🎯 Оберіть категорію, за якою ви бажаете отримувати повідомлення про нові вакансії, щойно вони з'являються на DOU", b.chatID, &options)
+	if id := indexOfCategory(b.selectedCategories, category.id); id != -1 {
+		b.selectedCategories = remove(b.selectedCategories, id)
+	} else {
+		b.selectedCategories = append(b.selectedCategories, category)
+	}
+	b.sendCategoriesMenu(update, "")
 
 	return b.handleSubscribeForCategory
 }
 
+func (b *bot) sendExperienceMenu(update *echotron.Update, warning string) {
+	btns := []echotron.InlineKeyboardButton{}
+	for _, name := range b.experienceNames() {
+		exp := b.telegramBot.douWorker.experienceFilters[name]
+		if indexOf(b.selectedExperiences, exp) != -1 {
+			name = "✅ " + name
+		}
+		btns = append(btns, callbackButton(name, experienceCallback, IdToDBId(exp)))
+	}
+
+	keyboard := append(inlineKeyboard(btns, 3), []echotron.InlineKeyboardButton{callbackButton("Далі ➡️", nextCallback, "")})
+	b.SendMenu(warning+"📈 Оберіть один чи кілька рівнів досвіду", update, keyboard)
+}
+
 func (b *bot) handleCategoryExperience(update *echotron.Update) stateFn {
 	r := b.handleCommands(update)
 	if r != nil {
 		return r
 	}
 
-	exp, err := b.findExperience(update.Message.Text)
+	if isCallback(update, nextCallback) {
+		if len(b.selectedExperiences) == 0 {
+			b.sendExperienceMenu(update, "🚫 Оберіть хоча б один рівень досвіду\n\n")
+			return b.handleCategoryExperience
+		}
+
+		b.sendSalaryMenu(update)
+		return b.handleCategorySalary
+	}
+
+	value, ok := readInput(update, experienceCallback)
+	if !ok {
+		return b.handleCategoryExperience
+	}
+
+	exp, _, err := b.findExperience(value)
 	if err != nil {
-		b.SendAutoDeleteMessage("🚫 Ви обрали не існуючий досвід", b.chatID, parseModeHTML)
-		return b.handleMessage
+		b.sendExperienceMenu(update, "🚫 Ви обрали не існуючий досвід\n\n")
+		return b.handleCategoryExperience
+	}
+
+	if id := indexOf(b.selectedExperiences, exp); id != -1 {
+		b.selectedExperiences = remove(b.selectedExperiences, id)
+	} else {
+		b.selectedExperiences = append(b.selectedExperiences, exp)
+	}
+	b.sendExperienceMenu(update, "")
+
+	return b.handleCategoryExperience
+}
+
+func (b *bot) handleCategorySalary(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
 	}
 
-	ok, err := b.telegramBot.storage.SubscribeUser(b.category, exp, int(update.Message.From.ID), b.chatID, update.Message.From.Username)
+	value, ok := readInput(update, salaryCallback)
+	if !ok {
+		return b.handleCategorySalary
+	}
+
+	minSalary, err := parseMinSalary(value)
 	if err != nil {
-		fmt.Println(err)
-		b.SendAutoDeleteMessage("🚫 Не вдалося підписатися, спробуйте ще", b.chatID, parseModeHTML)
-		return b.handleMessage
+		b.SendAutoDeleteMessage("🚫 Не вдалося розпізнати зарплату, вкажіть число, наприклад <i>3000</i>", b.chatID, parseModeHTML)
+		return b.handleCategorySalary
+	}
+
+	b.subscription.MinSalary = minSalary
+	b.sendNoSalaryMenu(update)
+
+	return b.handleCategoryNoSalary
+}
+
+func (b *bot) handleCategoryNoSalary(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
 	}
 
+	value, ok := readInput(update, noSalaryCallback)
 	if !ok {
-		b.SendAutoDeleteMessage(fmt.Sprintf("‼️ Ви вже підписані на <b>%s</b>", b.category.name), b.chatID, parseModeHTML)
-		return b.handleMessage
+		return b.handleCategoryNoSalary
 	}
 
-	b.SendAutoDeleteMessage(fmt.Sprintf("✅ Ви вдало підписалися на <b>%s(%s)</b>, щойно з'явиться нова вакансія - я одразу вас сповіщу👍", b.category.name,
-		formatString(update.Message.Text)), b.chatID, parseModeHTML)
+	if !b.readNoSalaryChoice(value) {
+		return b.handleCategoryNoSalary
+	}
+	b.sendLocationMenu(update)
 
-	return b.handleMessage
+	return b.handleCategoryLocation
 }
 
-func formatString(msg string) string {
-	msg = strings.Replace(msg, "<", "&lt;", -1)
-	msg = strings.Replace(msg, ">", "&gt;", -1)
+func (b *bot) sendSalaryMenu(update *echotron.Update) {
+	btns := []echotron.InlineKeyboardButton{}
+	for _, salary := range salaryOptions {
+		minSalary, _ := parseMinSalary(salary)
+		btns = append(btns, callbackButton(salary, salaryCallback, strconv.Itoa(minSalary)))
+	}
 
-	return msg
+	b.SendMenu("💰 Оберіть мінімальну зарплату в доларах, або надішліть своє значення", update, inlineKeyboard(btns, 3))
 }
 
-func (b *bot) handleSubscribeForCategory(update *echotron.Update) stateFn {
+func (b *bot) sendNoSalaryMenu(update *echotron.Update) {
+	btns := []echotron.InlineKeyboardButton{
+		callbackButton(showNoSalaryOption, noSalaryCallback, "show"),
+		callbackButton(hideNoSalaryOption, noSalaryCallback, "hide"),
+	}
+	b.SendMenu("🤔 Показувати вакансії, в яких не вказана зарплата?", update, inlineKeyboard(btns, 2))
+}
+
+// readNoSalaryChoice stores the answer to the no salary question, asks again if it wasn't recognized
+func (b *bot) readNoSalaryChoice(value string) bool {
+	switch value {
+	case "show", showNoSalaryOption:
+		b.subscription.HideNoSalary = false
+	case "hide", hideNoSalaryOption:
+		b.subscription.HideNoSalary = true
+	default:
+		b.SendAutoDeleteMessage("🚫 Оберіть один з варіантів", b.chatID, parseModeHTML)
+		return false
+	}
+	return true
+}
+
+func (b *bot) sendLocationMenu(update *echotron.Update) {
+	btns := []echotron.InlineKeyboardButton{}
+	for _, location := range locationOptions {
+		btns = append(btns, callbackButton(location, locationCallback, location))
+	}
+
+	b.SendMenu("📍 Оберіть місто або формат роботи, кілька варіантів можна надіслати через кому, наприклад: <i>Київ, Львів, Віддалено</i>", update, inlineKeyboard(btns, 3))
+}
+
+func (b *bot) handleCategoryLocation(update *echotron.Update) stateFn {
 	r := b.handleCommands(update)
 	if r != nil {
 		return r
 	}
 
-	category, err := b.findCategory(update.Message.Text)
-	if err != nil {
-		b.SendAutoDeleteMessage("🚫 Ви обрали не існуючу категорію", b.chatID, parseModeHTML)
+	value, ok := readInput(update, locationCallback)
+	if !ok {
+		return b.handleCategoryLocation
+	}
+
+	b.subscription.Cities, b.subscription.Remote, b.subscription.Relocation = parseLocations(value)
+	b.sendSubscribeSummary(update)
+	return b.handleSubscribeConfirm
+}
+
+func (b *bot) sendSubscribeSummary(update *echotron.Update) {
+	msg := "📝 <b>Перевірте нові підписки</b>\n\n"
+	for _, sub := range b.selectedSubscriptions() {
+		msg += fmt.Sprintf("• %s\n", formatString(b.formatSubscription(sub)))
+	}
+	msg += fmt.Sprintf("\n💰 Зарплата: <i>%s</i>\n📍 Місто: <i>%s</i>", formatSalaryFilter(b.subscription), formatString(formatLocationFilter(b.subscription)))
+
+	btns := []echotron.InlineKeyboardButton{
+		callbackButton("✅ Підтвердити", confirmCallback, "yes"),
+		callbackButton("❌ Скасувати", confirmCallback, "no"),
+	}
+	b.SendMenu(msg, update, inlineKeyboard(btns, 2))
+}
+
+func (b *bot) handleSubscribeConfirm(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
+	}
+
+	value, ok := readInput(update, confirmCallback)
+	if !ok {
+		return b.handleSubscribeConfirm
+	}
+
+	switch value {
+	case "yes":
+		return b.subscribe(update)
+	case "no":
+		b.SendMenu("👌 Підписку скасовано", update, nil)
 		return b.handleMessage
 	}
 
-	b.category = category
+	b.sendSubscribeSummary(update)
+	return b.handleSubscribeConfirm
+}
 
-	btns := [][]echotron.KeyboardButton{}
-	i := 0
-	for k, _ := range b.telegramBot.douWorker.experienceFilters {
-		if i%3 == 0 {
-			btns = append(btns, []echotron.KeyboardButton{})
+// selectedSubscriptions combines every selected category with every selected experience and chosen filters
+func (b *bot) selectedSubscriptions() []SubscriptionCategory {
+	subs := []SubscriptionCategory{}
+	for _, category := range b.selectedCategories {
+		for _, exp := range b.selectedExperiences {
+			sub := b.subscription
+			sub.IDCategory, sub.NameCategory, sub.Experience = IdToDBId(category.id), category.name, IdToDBId(exp)
+			subs = append(subs, sub)
 		}
-		i++
-		btns[len(btns)-1] = append(btns[len(btns)-1], echotron.KeyboardButton{Text: k})
+	}
+	return subs
+}
+
+func (b *bot) subscribe(update *echotron.Update) stateFn {
+	sender := updateSender(update)
+	subs := b.selectedSubscriptions()
+	added, err := b.telegramBot.storage.SubscribeMany(b.telegramBot.ctx, subs, int(sender.ID), b.targetChatID, b.subscriberName(update))
+	if err != nil {
+		fmt.Println(err)
+		b.SendMenu("🚫 Не вдалося підписатися, спробуйте ще", update, nil)
+		return b.handleMessage
+	}
+
+	if added == 0 {
+		b.SendMenu("‼️ Ви вже підписані на всі обрані категорії", update, nil)
+		return b.handleMessage
 	}
 
-	options := echotron.MessageOptions{
-		ReplyMarkup: echotron.ReplyKeyboardMarkup{
-			Keyboard:        btns,
-			OneTimeKeyboard: true,
-		}}
-	b.SendAutoDeleteMessage("📈 Оберіть досвід", b.chatID, &options)
+	b.SendMenu(fmt.Sprintf("✅ Ви вдало оформили нових підписок: <b>%d</b> з %d, щойно з'явиться нова вакансія - я одразу вас сповіщу👍", added, len(subs)), update, nil)
 
-	return b.handleCategoryExperience
+	return b.handleMessage
+}
+
+// parseMinSalary accepts salary options as well as plain numbers like `3000` or `$3 000`
+func parseMinSalary(text string) (int, error) {
+	if text == salaryOptions[0] {
+		return 0, nil
+	}
+
+	text = strings.Join(strings.Fields(strings.TrimPrefix(text, "$")), "")
+	minSalary, err := strconv.Atoi(text)
+	if err != nil || minSalary < 0 {
+		return 0, fmt.Errorf("Salary `%s` isn't valid", text)
+	}
+	return minSalary, nil
+}
+
+func formatString(msg string) string {
+	msg = strings.Replace(msg, "&", "&amp;", -1)
+	msg = strings.Replace(msg, "<", "&lt;", -1)
+	msg = strings.Replace(msg, ">", "&gt;", -1)
+
+	return msg
+}
+
+func (b *bot) formatSubscription(sub SubscriptionCategory) string {
+	for name, filter := range b.telegramBot.douWorker.experienceFilters {
+		if DBIdToId(sub.Experience) == filter {
+			return fmt.Sprintf("%s(%s)", sub.NameCategory, name)
+		}
+	}
+	return sub.NameCategory
 }
 
 func (b *bot) getCurrentSubscriptionStatus(update *echotron.Update) (*SubscriptionInfo, stateFn) {
-	subInfo, err := b.telegramBot.storage.GetSubscriptionInfo(int(update.Message.From.ID))
+	subInfo, err := b.telegramBot.storage.GetSubscriptionInfo(b.telegramBot.ctx, b.targetChatID)
 	if err != nil {
 		fmt.Println(err)
-		b.SendAutoDeleteMessage("🚫 Не вдалося отримати ваші підписки, спробуйте ще", b.chatID, parseModeHTML)
+		b.SendMenu("🚫 Не вдалося отримати ваші підписки, спробуйте ще", update, nil)
 		return nil, b.handleMessage
 	}
 
 	if len(subInfo.Subscriptions) == 0 {
-		b.SendAutoDeleteMessage("🚫 Ви не підписані на жодну з категорій, скористайтеся командою <b>/follow</b>", b.chatID, parseModeHTML)
+		b.SendMenu("🚫 Ви не підписані на жодну з категорій, скористайтеся командою <b>/follow</b>", update, nil)
 		return nil, b.handleMessage
 	}
 
@@ -299,20 +682,12 @@ func (b *bot) handleUnsubscribe(update *echotron.Update) stateFn {
 		return state
 	}
 
-	btns := [][]echotron.KeyboardButton{}
-	for id, category := range subInfo.Subscriptions {
-		if id%3 == 0 {
-			btns = append(btns, []echotron.KeyboardButton{})
-		}
-		btns[len(btns)-1] = append(btns[len(btns)-1], echotron.KeyboardButton{Text: category.NameCategory})
+	btns := []echotron.InlineKeyboardButton{}
+	for _, sub := range subInfo.Subscriptions {
+		btns = append(btns, callbackButton(b.formatSubscription(sub), unsubscribeCallback, sub.ID))
 	}
 
-	options := echotron.MessageOptions{
-		ReplyMarkup: echotron.ReplyKeyboardMarkup{
-			Keyboard:        btns,
-			OneTimeKeyboard: true,
-		}}
-	b.SendAutoDeleteMessage("👁 Оберіть категорію для відписки", b.chatID, &options)
+	b.SendMenu("👁 Оберіть підписку для відписки", update, inlineKeyboard(btns, 2))
 	return b.handleUnsubscribeFromCategory
 }
 
@@ -322,41 +697,83 @@ func (b *bot) handleUnsubscribeFromCategory(update *echotron.Update) stateFn {
 		return r
 	}
 
-	ok, err := b.telegramBot.storage.UnsubscribeUser(update.Message.Text, int(update.Message.From.ID))
+	value, ok := readInput(update, unsubscribeCallback)
+	if !ok {
+		return b.handleUnsubscribeFromCategory
+	}
+
+	subInfo, state := b.getCurrentSubscriptionStatus(update)
+	if subInfo == nil {
+		return state
+	}
+
+	sub, ok := b.findUserSubscription(*subInfo, value)
+	if !ok {
+		b.SendMenu("🚫 У вас немае підписки на: "+formatString(value), update, nil)
+		return b.handleMessage
+	}
+
+	name := b.formatSubscription(sub)
+	ok, err := b.telegramBot.storage.UnsubscribeUser(b.telegramBot.ctx, sub.ID, b.targetChatID)
 	if err != nil {
 		fmt.Println(err)
-		b.SendAutoDeleteMessage("🚫 Не вдалося видалитии підписку, спробуйте ще", b.chatID, parseModeHTML)
+		b.SendMenu("🚫 Не вдалося видалитии підписку, спробуйте ще", update, nil)
 		return b.handleMessage
 	}
 
 	if !ok {
-		b.SendAutoDeleteMessage("🚫 У вас немае підписки на: "+update.Message.Text, b.chatID, parseModeHTML)
+		b.SendMenu("🚫 У вас немае підписки на: "+formatString(name), update, nil)
 		return b.handleMessage
 	}
 
-	b.SendAutoDeleteMessage(fmt.Sprintf("✅ Підписка на <b>%s</b> видаленна ", update.Message.Text), b.chatID, parseModeHTML)
+	b.SendMenu(fmt.Sprintf("✅ Підписка на <b>%s</b> видаленна ", formatString(name)), update, nil)
 	return b.handleMessage
 }
 
-func (b *bot) findCategory(name string) (DouCategory, error) {
-	for _, c := range b.telegramBot.douWorker.categories {
-		if c.name == name {
+// findUserSubscription looks subscription up by id from buttons or by its name typed by user
+func (b *bot) findUserSubscription(subInfo SubscriptionInfo, value string) (SubscriptionCategory, bool) {
+	for _, sub := range subInfo.Subscriptions {
+		if sub.ID == value || b.formatSubscription(sub) == value {
+			return sub, true
+		}
+	}
+	return SubscriptionCategory{}, false
+}
+
+// findCategory looks category up by id from buttons or by name typed by user
+func (b *bot) findCategory(value string) (DouCategory, error) {
+	for _, c := range b.telegramBot.douWorker.Categories() {
+		if c.id == value || c.name == value {
 			return c, nil
 		}
 	}
-	return DouCategory{}, fmt.Errorf("Category `%s` wasn't found", name)
+	return DouCategory{}, fmt.Errorf("Category `%s` wasn't found", value)
 }
 
-func (b *bot) findExperience(name string) (string, error) {
+// findExperience looks experience filter up by id from buttons or by name typed by user
+func (b *bot) findExperience(value string) (string, string, error) {
 	for k, v := range b.telegramBot.douWorker.experienceFilters {
-		if k == name {
-			return v, nil
+		if k == value || IdToDBId(v) == value {
+			return v, k, nil
 		}
 	}
-	return "", fmt.Errorf("Experience `%s` wasn't found", name)
+	return "", "", fmt.Errorf("Experience `%s` wasn't found", value)
+}
 
+// experienceNames returns experience filters ordered from juniors to seniors
+func (b *bot) experienceNames() []string {
+	filters := b.telegramBot.douWorker.experienceFilters
+	names := []string{}
+	for name := range filters {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return filters[names[i]] < filters[names[j]]
+	})
+	return names
 }
 
+// SendAutoDeleteMessage replaces previous bot messages in private chats, in groups messages are kept for everyone to see
 func (b *bot) SendAutoDeleteMessage(text string, chatID int64, opts *echotron.MessageOptions) {
 	b.RemoveMessages()
 	res, err := b.SendMessage(text, chatID, opts)
@@ -364,24 +781,173 @@ func (b *bot) SendAutoDeleteMessage(text string, chatID int64, opts *echotron.Me
 		fmt.Println(err)
 		return
 	}
-	b.messagesIds = append(b.messagesIds, res.Result.ID)
+	if !isGroupChat(chatID) {
+		b.messagesIds = append(b.messagesIds, res.Result.ID)
+	}
 }
 
-func pullVacancies(tb *TelegramBot) {
-	for {
-		vacancy := <-tb.douWorker.newVacancyChan
-		subs, err := tb.storage.GetAllSubscribers(vacancy.categoryName, vacancy.categoryId, vacancy.experience)
-		if err != nil {
+// SendMenu edits the message which button was pressed in place, otherwise sends a new one
+func (b *bot) SendMenu(text string, update *echotron.Update, btns [][]echotron.InlineKeyboardButton) {
+	markup := echotron.InlineKeyboardMarkup{InlineKeyboard: btns}
+	if update.CallbackQuery != nil && update.CallbackQuery.Message != nil {
+		msgID := echotron.NewMessageID(b.chatID, update.CallbackQuery.Message.ID)
+		_, err := b.EditMessageText(text, msgID, &echotron.MessageTextOptions{ParseMode: echotron.HTML, ReplyMarkup: markup})
+		if err == nil || strings.Contains(err.Error(), "message is not modified") {
+			return
+		}
+		fmt.Println(err)
+	}
+
+	options := echotron.MessageOptions{ParseMode: echotron.HTML}
+	if len(btns) > 0 {
+		options.ReplyMarkup = markup
+	}
+	b.SendAutoDeleteMessage(text, b.chatID, &options)
+}
+
+func callbackButton(text string, prefix string, value string) echotron.InlineKeyboardButton {
+	return echotron.InlineKeyboardButton{Text: text, CallbackData: prefix + ":" + value}
+}
+
+func inlineKeyboard(btns []echotron.InlineKeyboardButton, rowSize int) [][]echotron.InlineKeyboardButton {
+	res := [][]echotron.InlineKeyboardButton{}
+	for id, btn := range btns {
+		if id%rowSize == 0 {
+			res = append(res, []echotron.InlineKeyboardButton{})
+		}
+		res[len(res)-1] = append(res[len(res)-1], btn)
+	}
+	return res
+}
+
+// readInput returns value of pressed button with expected prefix or text typed by user,
+// buttons of other steps are ignored
+func readInput(update *echotron.Update, prefix string) (string, bool) {
+	if update.CallbackQuery == nil {
+		return update.Message.Text, true
+	}
+
+	value, found := strings.CutPrefix(update.CallbackQuery.Data, prefix+":")
+	return value, found
+}
+
+func isCallback(update *echotron.Update, prefix string) bool {
+	return update.CallbackQuery != nil && strings.HasPrefix(update.CallbackQuery.Data, prefix+":")
+}
+
+func indexOfCategory(categories []DouCategory, id string) int {
+	for i, category := range categories {
+		if category.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexOf[T comparable](slice []T, value T) int {
+	for i, v := range slice {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func updateSender(update *echotron.Update) *echotron.User {
+	if update.CallbackQuery != nil {
+		return update.CallbackQuery.From
+	}
+	return update.Message.From
+}
+
+func formatVacancyMessage(vacancy DouVacancy) string {
+	msg := fmt.Sprintf("🔥<b>Нова вакансія🔥</b>\n\n <b>Категорія</b>: <i>%s</i> 👀 \n\n➡️<b>%s</b>\n", formatString(vacancy.categoryName), formatString(vacancy.position))
+	if vacancy.company != "" {
+		msg += fmt.Sprintf("🏢 %s\n", formatString(vacancy.company))
+	}
+	if vacancy.salary.IsStated() {
+		msg += fmt.Sprintf("💰 %s\n", formatString(vacancy.salary.String()))
+	}
+	if len(vacancy.cities) > 0 {
+		msg += fmt.Sprintf("📍 %s\n", formatString(strings.Join(vacancy.cities, ", ")))
+	}
+	if vacancy.remote {
+		msg += "🏠 Віддалено\n"
+	}
+	if vacancy.relocation {
+		msg += "✈️ Релокейт\n"
+	}
+	if vacancy.snippet != "" {
+		msg += fmt.Sprintf("\n<i>%s</i>\n", formatString(vacancy.snippet))
+	}
+	return msg + "\n" + vacancy.url
+}
+
+// pullVacancies delivers vacancies until DouWorker closes the channel, every handoff is acknowledged
+func pullVacancies(ctx context.Context, tb *TelegramBot) {
+	for handoff := range tb.douWorker.newVacancyChan {
+		handoff.handled <- deliverVacancy(ctx, tb, handoff.vacancy)
+	}
+}
+
+// deliverVacancy queues vacancy for its subscribers, the error means it has to be scraped again
+func deliverVacancy(ctx context.Context, tb *TelegramBot, vacancy DouVacancy) error {
+	subs, err := tb.storage.GetAllSubscribers(ctx, vacancy.categoryName, vacancy.categoryId, vacancy.experience)
+	if err != nil {
+		return err
+	}
+
+	var handoffErr error
+	for _, sub := range subs {
+		if !acceptsVacancy(sub, vacancy) {
+			fmt.Printf("Vacancy %s was filtered out for subscriber(%s)\n", vacancy.url, sub.UserName)
+			continue
+		}
+
+		if isNew, err := tb.storage.MarkVacancySent(ctx, sub.ChatId, vacancy.url); err != nil {
 			fmt.Println(err)
+		} else if !isNew {
+			fmt.Printf("Vacancy %s was already sent to subscriber(%s)\n", vacancy.url, sub.UserName)
 			continue
 		}
 
-		for _, sub := range subs {
+		if shouldHoldVacancies(sub, time.Now()) {
+			err = tb.storage.AddPendingVacancy(ctx, sub.ChatId, CreateVacancyRecord(vacancy))
+		} else {
 			fmt.Printf("Sending Vacancy to subscriber(%s): %+v\n", sub.UserName, vacancy)
-			b := newBotBroadcast(sub.ChatId).(*bot)
-			msg := fmt.Sprintf("🔥<b>Нова вакансія🔥</b>\n\n <b>Категорія</b>: <i>%s</i> 👀 \n\n➡️%s\n%s", vacancy.categoryName, vacancy.name, vacancy.url)
-			b.SendMessage(msg, sub.ChatId, parseModeHTML)
-			time.Sleep(100 * time.Millisecond)
+			err = tb.enqueueMessage(ctx, sub.ChatId, formatVacancyMessage(vacancy), false)
+		}
+		if err != nil {
+			// vacancy mustn't stay marked as sent, otherwise it is skipped when it is scraped again
+			fmt.Printf("Vacancy %s wasn't handed off to subscriber(%s): %v\n", vacancy.url, sub.UserName, err)
+			if err := tb.storage.UnmarkVacancySent(ctx, sub.ChatId, vacancy.url); err != nil {
+				fmt.Println(err)
+			}
+			handoffErr = err
+		}
+	}
+	return handoffErr
+}
+
+// enqueueMessage hands message to the outbox, it is sent as soon as telegram limits allow
+func (tb *TelegramBot) enqueueMessage(ctx context.Context, chatId int64, text string, disablePreview bool) error {
+	now := time.Now().UTC()
+	msg := OutboxMessage{ChatId: chatId, Text: text, DisablePreview: disablePreview, NextAttempt: now, CreateDate: now}
+	return tb.storage.EnqueueMessage(ctx, msg)
+}
+
+func cleanSentVacancies(ctx context.Context, tb *TelegramBot) {
+	ticker := time.NewTicker(cleanSentVacanciesInterval)
+	defer ticker.Stop()
+	for {
+		if err := tb.storage.RemoveSentVacanciesBefore(ctx, time.Now().UTC().Add(-sentVacancyTTL)); err != nil {
+			fmt.Println(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 	}
 }
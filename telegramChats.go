@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/NicoNex/echotron/v3"
+)
+
+const (
+	memberCreator       = "creator"
+	memberAdministrator = "administrator"
+	unlinkChannelOption = "-"
+)
+
+// readOnlyCommands can be used by any member of a group, others change subscriptions and require an admin
+var readOnlyCommands = []string{"/start", "/help", "/myfollows"}
+
+// isGroupChat tells if chat is a group, supergroup or channel, telegram gives them negative ids
+func isGroupChat(chatID int64) bool {
+	return chatID < 0
+}
+
+// commandName strips bot username from commands sent in groups like `/follow@dou_bot`
+func commandName(text string) string {
+	name, _, _ := strings.Cut(text, "@")
+	return name
+}
+
+func isAdminStatus(member *echotron.ChatMember) bool {
+	return member != nil && (member.Status == memberCreator || member.Status == memberAdministrator)
+}
+
+// isChatAdmin checks via getChatMember that sender of the update may manage subscriptions of the chat
+func (b *bot) isChatAdmin(chatID int64, update *echotron.Update) bool {
+	if !isGroupChat(chatID) {
+		return true
+	}
+
+	// anonymous admins write on behalf of the group itself
+	if update.Message != nil && update.Message.SenderChat != nil && update.Message.SenderChat.ID == chatID {
+		return true
+	}
+
+	sender := updateSender(update)
+	if sender == nil {
+		return false
+	}
+
+	res, err := b.GetChatMember(chatID, sender.ID)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return isAdminStatus(res.Result)
+}
+
+// allowUpdate lets only admins change subscriptions of groups and channels, other members can just look at them
+func (b *bot) allowUpdate(update *echotron.Update) bool {
+	if !isGroupChat(b.targetChatID) {
+		return true
+	}
+
+	if update.Message != nil && indexOf(readOnlyCommands, commandName(update.Message.Text)) != -1 {
+		return true
+	}
+
+	// members talk to each other, while no reply is awaited their messages are ignored by handleMessage anyway,
+	// so telegram isn't asked if the sender is an admin on every message
+	if update.Message != nil && !strings.HasPrefix(update.Message.Text, "/") && b.isIdle() {
+		return true
+	}
+
+	if b.isChatAdmin(b.targetChatID, update) {
+		return true
+	}
+
+	msg := "🚫 Змінювати підписки можуть лише адміністратори чату"
+	if update.CallbackQuery != nil {
+		b.AnswerCallbackQuery(update.CallbackQuery.ID, &echotron.CallbackQueryOptions{Text: msg, ShowAlert: true})
+	} else if strings.HasPrefix(update.Message.Text, "/") {
+		b.SendAutoDeleteMessage(msg, b.chatID, parseModeHTML)
+	}
+	return false
+}
+
+// isIdle tells if session doesn't wait for a reply to one of its questions
+func (b *bot) isIdle() bool {
+	return reflect.ValueOf(b.state).Pointer() == reflect.ValueOf(b.handleMessage).Pointer()
+}
+
+// subscriberName is stored with subscriptions to tell whose they are in logs
+func (b *bot) subscriberName(update *echotron.Update) string {
+	if b.targetChatName != "" {
+		return b.targetChatName
+	}
+	return updateSender(update).Username
+}
+
+func (b *bot) handleChannel(update *echotron.Update) stateFn {
+	if isGroupChat(b.chatID) {
+		b.SendAutoDeleteMessage("🚫 Підключити канал можна лише в особистому чаті з ботом", b.chatID, parseModeHTML)
+		return b.handleMessage
+	}
+
+	msg := "📢 Додайте бота адміністратором каналу з правом публікації повідомлень, а потім перешліть сюди будь-який пост з каналу або надішліть його id\n\n"
+	if b.targetChatID != b.chatID {
+		msg = fmt.Sprintf("📢 Зараз підключено канал <b>%s</b>\n\n", formatString(b.targetChatName)) + msg
+	}
+	msg += fmt.Sprintf("Надішліть <i>%s</i> щоб знову керувати підписками цього чату", unlinkChannelOption)
+	b.SendMenu(msg, update, nil)
+	return b.handleChannelLink
+}
+
+func (b *bot) handleChannelLink(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
+	}
+
+	if update.Message == nil {
+		return b.handleChannelLink
+	}
+
+	if update.Message.Text == unlinkChannelOption {
+		b.targetChatID, b.targetChatName = b.chatID, ""
+		if err := b.telegramBot.storage.RemoveChannelLink(b.telegramBot.ctx, b.chatID); err != nil {
+			fmt.Println(err)
+		}
+		b.SendAutoDeleteMessage("✅ Тепер команди керують підписками цього чату", b.chatID, parseModeHTML)
+		return b.handleMessage
+	}
+
+	channelID, err := strconv.ParseInt(strings.TrimSpace(update.Message.Text), 10, 64)
+	if update.Message.ForwardFromChat != nil {
+		channelID, err = update.Message.ForwardFromChat.ID, nil
+	}
+	if err != nil || !isGroupChat(channelID) {
+		b.SendAutoDeleteMessage("🚫 Не вдалося розпізнати канал, перешліть пост з нього або надішліть його id", b.chatID, parseModeHTML)
+		return b.handleChannelLink
+	}
+
+	if !b.isChatAdmin(channelID, update) {
+		b.SendAutoDeleteMessage("🚫 Підключити канал може лише його адміністратор", b.chatID, parseModeHTML)
+		return b.handleMessage
+	}
+
+	if !b.canPostTo(channelID) {
+		b.SendAutoDeleteMessage("🚫 Бот не може публікувати в цьому каналі, додайте його адміністратором з правом публікації", b.chatID, parseModeHTML)
+		return b.handleMessage
+	}
+
+	chat, err := b.GetChat(channelID)
+	if err != nil {
+		fmt.Println(err)
+		b.SendAutoDeleteMessage("🚫 Не вдалося отримати інформацію про канал, спробуйте ще", b.chatID, parseModeHTML)
+		return b.handleMessage
+	}
+
+	b.targetChatID, b.targetChatName = channelID, chat.Result.Title
+	link := ChannelLink{ChatId: b.chatID, ChannelId: channelID, ChannelName: chat.Result.Title}
+	if err := b.telegramBot.storage.SetChannelLink(b.telegramBot.ctx, link); err != nil {
+		fmt.Println(err)
+	}
+	b.SendAutoDeleteMessage(fmt.Sprintf("✅ Канал <b>%s</b> підключено, наступні команди керуватимуть його підписками, а вакансії публікуватимуться в ньому",
+		formatString(chat.Result.Title)), b.chatID, parseModeHTML)
+	return b.handleMessage
+}
+
+// restoreChannelLink brings back channel linked in one of previous sessions,
+// the link is dropped once the user isn't an admin of the channel anymore
+func (b *bot) restoreChannelLink() {
+	if isGroupChat(b.chatID) {
+		return
+	}
+
+	link, err := b.telegramBot.storage.GetChannelLink(b.telegramBot.ctx, b.chatID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if link.ChannelId == 0 {
+		return
+	}
+
+	// in private chats user id equals chat id
+	res, err := b.GetChatMember(link.ChannelId, b.chatID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !isAdminStatus(res.Result) {
+		fmt.Printf("Chat %d isn't an admin of channel %d anymore, unlinking it\n", b.chatID, link.ChannelId)
+		if err := b.telegramBot.storage.RemoveChannelLink(b.telegramBot.ctx, b.chatID); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	b.targetChatID, b.targetChatName = link.ChannelId, link.ChannelName
+}
+
+// canPostTo checks that bot is a channel admin allowed to publish posts
+func (b *bot) canPostTo(channelID int64) bool {
+	me, err := b.GetMe()
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	res, err := b.GetChatMember(channelID, me.Result.ID)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return res.Result.Status == memberCreator || (res.Result.Status == memberAdministrator && res.Result.CanPostMessages)
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NicoNex/echotron/v3"
+)
+
+const (
+	editExperienceOption = "📈 Досвід"
+	editSalaryOption     = "💰 Зарплата"
+	editLocationOption   = "📍 Місто"
+	editKeywordsOption   = "🔎 Ключові слова"
+)
+
+func (b *bot) handleFilter(update *echotron.Update) stateFn {
+	subInfo, state := b.getCurrentSubscriptionStatus(update)
+	if subInfo == nil {
+		return state
+	}
+
+	btns := []echotron.InlineKeyboardButton{}
+	for _, sub := range subInfo.Subscriptions {
+		btns = append(btns, callbackButton(b.formatSubscription(sub), filterCallback, sub.ID))
+	}
+
+	b.SendMenu("🔎 Оберіть підписку для налаштування фільтрів", update, inlineKeyboard(btns, 2))
+	return b.handleFilterForCategory
+}
+
+func (b *bot) handleFilterForCategory(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
+	}
+
+	value, ok := readInput(update, filterCallback)
+	if !ok {
+		return b.handleFilterForCategory
+	}
+
+	subInfo, state := b.getCurrentSubscriptionStatus(update)
+	if subInfo == nil {
+		return state
+	}
+
+	sub, isFound := b.findUserSubscription(*subInfo, value)
+	if !isFound {
+		b.SendMenu("🚫 У вас немае підписки на: "+formatString(value), update, nil)
+		return b.handleMessage
+	}
+
+	b.subscription = sub
+	b.sendKeywordsPrompt(update)
+	return b.handleFilterKeywords
+}
+
+func (b *bot) sendKeywordsPrompt(update *echotron.Update) {
+	msg := fmt.Sprintf("🔎 Поточні фільтри для <b>%s</b>: <i>%s</i>\n\n", formatString(b.formatSubscription(b.subscription)), formatString(formatKeywords(b.subscription)))
+	msg += "Надішліть ключові слова через кому, слова для виключення починайте з мінуса, наприклад: <i>remote, -senior</i>\n\n"
+	msg += "Надішліть <i>-</i> щоб прибрати всі фільтри"
+	b.SendMenu(msg, update, nil)
+}
+
+func (b *bot) handleFilterKeywords(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
+	}
+
+	if update.Message == nil {
+		return b.handleFilterKeywords
+	}
+
+	b.subscription.IncludeKeywords, b.subscription.ExcludeKeywords = parseKeywords(update.Message.Text)
+	ok, err := b.telegramBot.storage.UpdateSubscription(b.telegramBot.ctx, b.targetChatID, b.subscription)
+	if err != nil {
+		fmt.Println(err)
+		b.SendAutoDeleteMessage("🚫 Не вдалося зберегти фільтри, спробуйте ще", b.chatID, parseModeHTML)
+		return b.handleMessage
+	}
+
+	if !ok {
+		b.SendAutoDeleteMessage("🚫 У вас немае підписки на: "+formatString(b.formatSubscription(b.subscription)), b.chatID, parseModeHTML)
+		return b.handleMessage
+	}
+
+	b.SendAutoDeleteMessage(fmt.Sprintf("✅ Фільтри для <b>%s</b> збережено: <i>%s</i>", formatString(b.formatSubscription(b.subscription)),
+		formatString(formatKeywords(b.subscription))), b.chatID, parseModeHTML)
+	return b.handleMessage
+}
+
+func (b *bot) handleEdit(update *echotron.Update) stateFn {
+	subInfo, state := b.getCurrentSubscriptionStatus(update)
+	if subInfo == nil {
+		return state
+	}
+
+	btns := []echotron.InlineKeyboardButton{}
+	for _, sub := range subInfo.Subscriptions {
+		btns = append(btns, callbackButton(b.formatSubscription(sub), editCallback, sub.ID))
+	}
+
+	b.SendMenu("✏️ Оберіть підписку, яку бажаете змінити", update, inlineKeyboard(btns, 2))
+	return b.handleEditSubscription
+}
+
+func (b *bot) handleEditSubscription(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
+	}
+
+	value, ok := readInput(update, editCallback)
+	if !ok {
+		return b.handleEditSubscription
+	}
+
+	subInfo, state := b.getCurrentSubscriptionStatus(update)
+	if subInfo == nil {
+		return state
+	}
+
+	sub, isFound := b.findUserSubscription(*subInfo, value)
+	if !isFound {
+		b.SendMenu("🚫 У вас немае підписки на: "+formatString(value), update, nil)
+		return b.handleMessage
+	}
+	b.subscription = sub
+
+	msg := fmt.Sprintf("✏️ <b>%s</b>\n\n💰 Зарплата: <i>%s</i>\n📍 Місто: <i>%s</i>\n🔎 Ключові слова: <i>%s</i>\n\nЩо бажаете змінити?",
+		formatString(b.formatSubscription(sub)), formatSalaryFilter(sub), formatString(formatLocationFilter(sub)), formatString(formatKeywords(sub)))
+	btns := []echotron.InlineKeyboardButton{
+		callbackButton(editExperienceOption, fieldCallback, "exp"),
+		callbackButton(editSalaryOption, fieldCallback, "sal"),
+		callbackButton(editLocationOption, fieldCallback, "loc"),
+		callbackButton(editKeywordsOption, fieldCallback, "kw"),
+	}
+	b.SendMenu(msg, update, inlineKeyboard(btns, 2))
+	return b.handleEditField
+}
+
+func (b *bot) handleEditField(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
+	}
+
+	value, ok := readInput(update, fieldCallback)
+	if !ok {
+		return b.handleEditField
+	}
+
+	switch value {
+	case "exp", editExperienceOption:
+		btns := []echotron.InlineKeyboardButton{}
+		for _, name := range b.experienceNames() {
+			btns = append(btns, callbackButton(name, experienceCallback, IdToDBId(b.telegramBot.douWorker.experienceFilters[name])))
+		}
+		b.SendMenu("📈 Оберіть новий досвід", update, inlineKeyboard(btns, 3))
+		return b.handleEditExperience
+	case "sal", editSalaryOption:
+		b.sendSalaryMenu(update)
+		return b.handleEditSalary
+	case "loc", editLocationOption:
+		b.sendLocationMenu(update)
+		return b.handleEditLocation
+	case "kw", editKeywordsOption:
+		b.sendKeywordsPrompt(update)
+		return b.handleFilterKeywords
+	}
+
+	b.SendAutoDeleteMessage("🚫 Оберіть один з варіантів", b.chatID, parseModeHTML)
+	return b.handleEditField
+}
+
+func (b *bot) handleEditExperience(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
+	}
+
+	value, ok := readInput(update, experienceCallback)
+	if !ok {
+		return b.handleEditExperience
+	}
+
+	exp, _, err := b.findExperience(value)
+	if err != nil {
+		b.SendAutoDeleteMessage("🚫 Ви обрали не існуючий досвід", b.chatID, parseModeHTML)
+		return b.handleEditExperience
+	}
+
+	b.subscription.Experience = IdToDBId(exp)
+	return b.saveSubscription(update)
+}
+
+func (b *bot) handleEditSalary(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
+	}
+
+	value, ok := readInput(update, salaryCallback)
+	if !ok {
+		return b.handleEditSalary
+	}
+
+	minSalary, err := parseMinSalary(value)
+	if err != nil {
+		b.SendAutoDeleteMessage("🚫 Не вдалося розпізнати зарплату, вкажіть число, наприклад <i>3000</i>", b.chatID, parseModeHTML)
+		return b.handleEditSalary
+	}
+
+	b.subscription.MinSalary = minSalary
+	b.sendNoSalaryMenu(update)
+	return b.handleEditNoSalary
+}
+
+func (b *bot) handleEditNoSalary(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
+	}
+
+	value, ok := readInput(update, noSalaryCallback)
+	if !ok {
+		return b.handleEditNoSalary
+	}
+
+	if !b.readNoSalaryChoice(value) {
+		return b.handleEditNoSalary
+	}
+	return b.saveSubscription(update)
+}
+
+func (b *bot) handleEditLocation(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
+	}
+
+	value, ok := readInput(update, locationCallback)
+	if !ok {
+		return b.handleEditLocation
+	}
+
+	b.subscription.Cities, b.subscription.Remote, b.subscription.Relocation = parseLocations(value)
+	return b.saveSubscription(update)
+}
+
+func (b *bot) saveSubscription(update *echotron.Update) stateFn {
+	ok, err := b.telegramBot.storage.UpdateSubscription(b.telegramBot.ctx, b.targetChatID, b.subscription)
+	if errors.Is(err, ErrSubscriptionExists) {
+		b.SendMenu("‼️ Ви вже підписані на: "+formatString(b.formatSubscription(b.subscription)), update, nil)
+		return b.handleMessage
+	}
+	if err != nil {
+		fmt.Println(err)
+		b.SendMenu("🚫 Не вдалося змінити підписку, спробуйте ще", update, nil)
+		return b.handleMessage
+	}
+
+	if !ok {
+		b.SendMenu("🚫 Підписку не знайдено, можливо її вже видалено", update, nil)
+		return b.handleMessage
+	}
+
+	b.SendMenu(fmt.Sprintf("✅ Підписку змінено: <b>%s</b>\n\n💰 Зарплата: <i>%s</i>\n📍 Місто: <i>%s</i>", formatString(b.formatSubscription(b.subscription)),
+		formatSalaryFilter(b.subscription), formatString(formatLocationFilter(b.subscription))), update, nil)
+	return b.handleMessage
+}
+
+func (b *bot) handleMode(update *echotron.Update) stateFn {
+	subInfo, state := b.getCurrentSubscriptionStatus(update)
+	if subInfo == nil {
+		return state
+	}
+
+	btns := []echotron.InlineKeyboardButton{}
+	for _, mode := range deliveryModes {
+		btns = append(btns, callbackButton(mode.name, modeCallback, mode.mode))
+	}
+
+	b.SendMenu("⏰ Оберіть, як часто надсилати нові вакансії", update, inlineKeyboard(btns, 4))
+	return b.handleModeChoice
+}
+
+func (b *bot) handleModeChoice(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
+	}
+
+	value, ok := readInput(update, modeCallback)
+	if !ok {
+		return b.handleModeChoice
+	}
+
+	mode, err := findDeliveryMode(value, update.CallbackQuery != nil)
+	if err != nil {
+		b.SendMenu("🚫 Ви обрали не існуючий режим", update, nil)
+		return b.handleMessage
+	}
+
+	if mode != dailyMode && mode != weeklyMode {
+		return b.setDeliveryMode(update, mode, 0)
+	}
+
+	b.deliveryMode = mode
+	btns := []echotron.InlineKeyboardButton{}
+	for _, hour := range digestHourOptions {
+		btns = append(btns, callbackButton(hour, hourCallback, hour))
+	}
+
+	b.SendMenu("🕘 Оберіть годину за вашим часовим поясом, або надішліть свою, наприклад 7:00", update, inlineKeyboard(btns, 3))
+	return b.handleModeHour
+}
+
+func (b *bot) handleModeHour(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
+	}
+
+	value, ok := readInput(update, hourCallback)
+	if !ok {
+		return b.handleModeHour
+	}
+
+	hour, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), ":00"))
+	if err != nil || hour < 0 || hour > 23 {
+		b.SendAutoDeleteMessage("🚫 Не вдалося розпізнати годину, вкажіть число від 0 до 23", b.chatID, parseModeHTML)
+		return b.handleModeHour
+	}
+
+	return b.setDeliveryMode(update, b.deliveryMode, hour)
+}
+
+func (b *bot) setDeliveryMode(update *echotron.Update, mode string, hour int) stateFn {
+	if err := b.telegramBot.storage.SetDeliveryMode(b.telegramBot.ctx, b.targetChatID, mode, hour); err != nil {
+		fmt.Println(err)
+		b.SendMenu("🚫 Не вдалося змінити режим, спробуйте ще", update, nil)
+		return b.handleMessage
+	}
+
+	msg := "✅ Тепер нові вакансії надходитимуть одразу"
+	switch mode {
+	case hourlyMode:
+		msg = "✅ Тепер нові вакансії надходитимуть дайджестом щогодини"
+	case dailyMode:
+		msg = fmt.Sprintf("✅ Тепер нові вакансії надходитимуть дайджестом щодня о %02d:00", hour)
+	case weeklyMode:
+		msg = fmt.Sprintf("✅ Тепер нові вакансії надходитимуть дайджестом щопонеділка о %02d:00", hour)
+	}
+	b.SendMenu(msg, update, nil)
+	return b.handleMessage
+}
+
+func (b *bot) handleQuiet(update *echotron.Update) stateFn {
+	subInfo, state := b.getCurrentSubscriptionStatus(update)
+	if subInfo == nil {
+		return state
+	}
+
+	btns := []echotron.InlineKeyboardButton{}
+	for _, timezone := range timezoneOptions {
+		btns = append(btns, callbackButton(timezone, timezoneCallback, timezone))
+	}
+
+	b.SendMenu(fmt.Sprintf("🌍 Ваш часовий пояс: <i>%s</i>\n\nОберіть часовий пояс або надішліть свій, наприклад <i>Europe/Prague</i>",
+		userLocation(*subInfo).String()), update, inlineKeyboard(btns, 3))
+	return b.handleQuietTimezone
+}
+
+func (b *bot) handleQuietTimezone(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
+	}
+
+	value, ok := readInput(update, timezoneCallback)
+	if !ok {
+		return b.handleQuietTimezone
+	}
+
+	if _, err := time.LoadLocation(value); err != nil || value == "" {
+		b.SendAutoDeleteMessage("🚫 Не вдалося розпізнати часовий пояс, спробуйте ще", b.chatID, parseModeHTML)
+		return b.handleQuietTimezone
+	}
+	b.timezone = value
+
+	btns := []echotron.InlineKeyboardButton{}
+	for _, quietHours := range quietHoursOptions {
+		btns = append(btns, callbackButton(quietHours, quietCallback, quietHours))
+	}
+
+	b.SendMenu("🌙 Оберіть тихі години або надішліть свої, наприклад <i>23-7</i>", update, inlineKeyboard(btns, 4))
+	return b.handleQuietHours
+}
+
+func (b *bot) handleQuietHours(update *echotron.Update) stateFn {
+	r := b.handleCommands(update)
+	if r != nil {
+		return r
+	}
+
+	value, ok := readInput(update, quietCallback)
+	if !ok {
+		return b.handleQuietHours
+	}
+
+	quietFrom, quietTo, err := parseQuietHours(value)
+	if err != nil {
+		b.SendAutoDeleteMessage("🚫 Не вдалося розпізнати тихі години, вкажіть їх у форматі <i>23-7</i>", b.chatID, parseModeHTML)
+		return b.handleQuietHours
+	}
+
+	if err := b.telegramBot.storage.SetQuietHours(b.telegramBot.ctx, b.targetChatID, b.timezone, quietFrom, quietTo); err != nil {
+		fmt.Println(err)
+		b.SendMenu("🚫 Не вдалося зберегти тихі години, спробуйте ще", update, nil)
+		return b.handleMessage
+	}
+
+	if quietFrom == quietTo {
+		b.SendMenu("✅ Тихі години вимкнено", update, nil)
+		return b.handleMessage
+	}
+
+	b.SendMenu(fmt.Sprintf("✅ З %02d:00 до %02d:00 (%s) вакансії чекатимуть, а потім надійдуть одним повідомленням", quietFrom, quietTo,
+		formatString(b.timezone)), update, nil)
+	return b.handleMessage
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NicoNex/echotron/v3"
+)
+
+// brokenOutboxStorage can't queue messages
+type brokenOutboxStorage struct {
+	*MemoryStorage
+}
+
+func (bs brokenOutboxStorage) EnqueueMessage(ctx context.Context, msg OutboxMessage) error {
+	return errors.New("outbox is unavailable")
+}
+
+func TestVacancyStaysUnsentWhenQueueingFails(t *testing.T) {
+	ctx := context.Background()
+	storage := brokenOutboxStorage{CreateMemoryStorage()}
+	golang := DouCategory{id: "Golang", name: "Golang"}
+	if _, err := storage.SubscribeUser(ctx, CreateSubscriptionCategory(golang, "1-3"), 1, 1, "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	vacancies := make(chan vacancyHandoff, 1)
+	handled := make(chan error, 1)
+	vacancies <- vacancyHandoff{
+		vacancy: DouVacancy{url: "https://jobs.dou.ua/1", categoryId: "Golang", categoryName: "Golang", experience: "1-3"},
+		handled: handled,
+	}
+	close(vacancies)
+	pullVacancies(ctx, &TelegramBot{storage: storage, douWorker: &DouWorker{newVacancyChan: vacancies}})
+	if err := <-handled; err == nil {
+		t.Error("vacancy which wasn't queued was acknowledged")
+	}
+
+	isNew, err := storage.MarkVacancySent(ctx, 1, "https://jobs.dou.ua/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isNew {
+		t.Error("vacancy which wasn't queued is marked as sent")
+	}
+}
+
+func TestGroupChatterIsAllowedWithoutAdminCheck(t *testing.T) {
+	b := &bot{chatID: -100, targetChatID: -100}
+	b.state = b.handleMessage
+	if !b.isIdle() {
+		t.Fatal("session waiting for commands isn't idle")
+	}
+
+	update := &echotron.Update{Message: &echotron.Message{Text: "hello", Chat: echotron.Chat{ID: -100}, From: &echotron.User{ID: 1}}}
+	if !b.allowUpdate(update) {
+		t.Error("plain message of an idle group session was rejected")
+	}
+
+	b.state = b.handleChannelLink
+	if b.isIdle() {
+		t.Error("session waiting for a reply is idle")
+	}
+}
+
+func TestDigestKeepsVacanciesWhenQueueingFails(t *testing.T) {
+	ctx := context.Background()
+	storage := brokenOutboxStorage{CreateMemoryStorage()}
+	if err := storage.AddPendingVacancy(ctx, 1, VacancyRecord{Url: "https://jobs.dou.ua/1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sendDigest(ctx, &TelegramBot{storage: storage}, SubscriptionInfo{ChatId: 1}, time.Now())
+	if vacancies, _ := storage.GetPendingVacancies(ctx, 1); len(vacancies) != 1 {
+		t.Errorf("vacancies of digest which wasn't queued were removed: %+v", vacancies)
+	}
+}